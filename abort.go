@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// abortBehaviors maps /abort/{name} to what is written to the raw
+// connection before it is closed
+var abortBehaviors = map[string]func(conn net.Conn){
+	// RST instead of FIN
+	"reset": func(conn net.Conn) {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+	},
+	"close-before-headers": func(conn net.Conn) {},
+	"close-after-headers": func(conn net.Conn) {
+		fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 1024\r\n\r\n")
+	},
+	// Chunked body without the terminating zero-length chunk
+	"close-mid-body": func(conn net.Conn) {
+		fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n")
+		fmt.Fprint(conn, "1a\r\nabcdefghijklmnopqrstuvwxyz\r\n")
+	},
+	"short-body": func(conn net.Conn) {
+		fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 1024\r\n\r\n")
+		fmt.Fprint(conn, "this body is shorter than its Content-Length\n")
+	},
+	"bad-chunk": func(conn net.Conn) {
+		fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n")
+		fmt.Fprint(conn, "zz\r\nnot a valid chunk size\r\n0\r\n\r\n")
+	},
+	"bad-status-line": func(conn net.Conn) {
+		fmt.Fprint(conn, "HTTP/1.1 2OO OK\r\nContent-Type: text/plain\r\nContent-Length: 3\r\n\r\nok\n")
+	},
+	"garbage": func(conn net.Conn) {
+		fmt.Fprint(conn, "\x00\x01\x02 this is not HTTP \xff\xfe\r\n\r\n")
+	},
+}
+
+// abortHandler handles /abort/{name} requests by taking over the connection
+// and producing a broken response
+func abortHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	name := strings.TrimPrefix(r.URL.Path, "/abort/")
+	behavior, ok := abortBehaviors[name]
+	if !ok {
+		names := make([]string, 0, len(abortBehaviors))
+		for n := range abortBehaviors {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     fmt.Sprintf("unknown abort behavior %q", name),
+			"available": names,
+		})
+		return
+	}
+
+	delay, err := parseStreamDuration(r.URL.Query().Get("delay"), 0)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("invalid delay: %v", err),
+		})
+		return
+	}
+
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("connection cannot be taken over: %v", err),
+		})
+		return
+	}
+	defer conn.Close()
+
+	setSpanAttribute(r.Context(), "debug_httpd.fault.abort", name)
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	behavior(conn)
+}
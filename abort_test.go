@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAbortHandler(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		// Whether the status line and headers are readable
+		headers bool
+		errText string
+	}{
+		{"reset", false, "reset"},
+		{"close-before-headers", false, "EOF"},
+		{"close-after-headers", true, "unexpected EOF"},
+		{"close-mid-body", true, "unexpected EOF"},
+		{"short-body", true, "unexpected EOF"},
+		{"bad-chunk", true, "invalid byte in chunk length"},
+		{"bad-status-line", false, "malformed HTTP status code"},
+		{"garbage", false, "malformed HTTP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			resp, err := client.Get(server.URL + "/abort/" + tt.name)
+			if !tt.headers {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected the request to fail")
+				}
+				if !strings.Contains(err.Error(), tt.errText) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected readable headers, got %v", err)
+			}
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("unexpected body error: %v", err)
+			}
+		})
+	}
+}
+
+func TestAbortHandler_Unknown(t *testing.T) {
+	req, err := http.NewRequest("GET", "/abort/explode", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(abortHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	if !strings.Contains(rr.Body.String(), "close-mid-body") {
+		t.Errorf("response does not list available behaviors: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// affinityConfig issues and checks sticky-session cookies. It is nil unless
+// enabled with the -affinity-cookie flag.
+var affinityConfig *AffinityConfig
+
+// AffinityConfig pins clients to this instance with a cookie holding its
+// hostname, the way a load balancer's session affinity does
+type AffinityConfig struct {
+	cookieName string
+	instance   string
+	attrs      cookieAttributes
+}
+
+// AffinityResult describes the affinity cookie of a request. Status is hit
+// when the cookie names this instance, miss when it names another one, new
+// when there is no cookie and invalid when the cookie is malformed.
+type AffinityResult struct {
+	Status   string `json:"status"`
+	Instance string `json:"instance"`
+	Cookie   string `json:"cookie,omitempty"`
+}
+
+// NewAffinityConfig creates the configuration for the cookie name, with
+// attributes in Set-Cookie syntax
+func NewAffinityConfig(cookieName, attrs string) (*AffinityConfig, error) {
+	parsed, err := parseCookieAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := (&http.Cookie{Name: cookieName}).Valid(); err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &AffinityConfig{cookieName: cookieName, instance: hostname, attrs: parsed}, nil
+}
+
+// String returns a description of the configuration for logging
+func (c *AffinityConfig) String() string {
+	return fmt.Sprintf("cookie %s=%s", c.cookieName, c.instance)
+}
+
+// check compares the affinity cookie of r with this instance
+func (c *AffinityConfig) check(r *http.Request) *AffinityResult {
+	result := &AffinityResult{Status: "new", Instance: c.instance}
+	cookie, err := r.Cookie(c.cookieName)
+	if err != nil {
+		return result
+	}
+
+	result.Cookie = cookie.Value
+	switch {
+	case !isAffinityValue(cookie.Value):
+		result.Status = "invalid"
+	case cookie.Value == c.instance:
+		result.Status = "hit"
+	default:
+		result.Status = "miss"
+	}
+	return result
+}
+
+// isAffinityValue reports whether s looks like a hostname
+func isAffinityValue(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+type affinityKey struct{}
+
+// withAffinity checks the affinity cookie of each request when affinity is
+// enabled, reporting the result in the X-Affinity and X-Affinity-Instance
+// headers. Clients without a cookie for this instance are pinned to it.
+func withAffinity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := affinityConfig
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result := c.check(r)
+		w.Header().Set("X-Affinity", result.Status)
+		w.Header().Set("X-Affinity-Instance", c.instance)
+		if result.Status != "hit" {
+			c.attrs.setCookie(w, c.cookieName, c.instance)
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), affinityKey{}, result)))
+	})
+}
+
+// affinityFromRequest returns the result stored by withAffinity, or nil if
+// affinity is disabled
+func affinityFromRequest(r *http.Request) *AffinityResult {
+	result, _ := r.Context().Value(affinityKey{}).(*AffinityResult)
+	return result
+}
+
+// logsStatsHandler handles /logs/stats requests by summarizing the access
+// logs, which hold the last logger.Size() requests including this one
+func logsStatsHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	logs := logger.GetLogs()
+	counts := map[string]int{"hit": 0, "miss": 0, "new": 0, "invalid": 0}
+	for _, log := range logs {
+		if log.Affinity != "" {
+			counts[log.Affinity]++
+		}
+	}
+
+	affinity := map[string]interface{}{
+		"enabled": affinityConfig != nil,
+		"hit":     counts["hit"],
+		"miss":    counts["miss"],
+		"new":     counts["new"],
+		"invalid": counts["invalid"],
+	}
+	if affinityConfig != nil {
+		affinity["instance"] = affinityConfig.instance
+	}
+	// The hit rate only counts requests that carried a well-formed cookie
+	if sticky := counts["hit"] + counts["miss"]; sticky > 0 {
+		affinity["hit_rate"] = float64(counts["hit"]) / float64(sticky)
+	}
+
+	response := map[string]interface{}{
+		"total":    len(logs),
+		"window":   logger.Size(),
+		"affinity": affinity,
+	}
+	if len(logs) > 0 {
+		response["first_id"] = logs[0].ID
+		response["since"] = logs[0].Timestamp
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withAffinityCookie enables affinity for the duration of a test, with
+// instance as this server's hostname
+func withAffinityCookie(t *testing.T, instance string) {
+	config, err := NewAffinityConfig("SERVERID", "Path=/; HttpOnly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.instance = instance
+	original := affinityConfig
+	affinityConfig = config
+	t.Cleanup(func() { affinityConfig = original })
+}
+
+func TestWithAffinity(t *testing.T) {
+	withAffinityCookie(t, "app-1")
+	handler := newHandler()
+
+	tests := []struct {
+		cookie    string
+		expected  string
+		setCookie bool
+	}{
+		{"", "new", true},
+		{"SERVERID=app-1", "hit", false},
+		{"SERVERID=app-2", "miss", true},
+		{`SERVERID="bad value"`, "invalid", true},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.cookie != "" {
+			req.Header.Set("Cookie", tt.cookie)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("X-Affinity"); got != tt.expected {
+			t.Errorf("%q: X-Affinity got %q want %q", tt.cookie, got, tt.expected)
+		}
+		if got := rr.Header().Get("X-Affinity-Instance"); got != "app-1" {
+			t.Errorf("%q: X-Affinity-Instance got %q want %q", tt.cookie, got, "app-1")
+		}
+		setCookie := rr.Header().Get("Set-Cookie")
+		if tt.setCookie != (setCookie == "SERVERID=app-1; Path=/; HttpOnly") {
+			t.Errorf("%q: unexpected Set-Cookie %q", tt.cookie, setCookie)
+		}
+
+		var response struct {
+			Affinity *AffinityResult `json:"affinity"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if response.Affinity == nil || response.Affinity.Status != tt.expected {
+			t.Errorf("%q: unexpected affinity in response: %v", tt.cookie, response.Affinity)
+		}
+	}
+}
+
+func TestWithAffinity_Disabled(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	req.Header.Set("Cookie", "SERVERID=app-1")
+	rr := httptest.NewRecorder()
+	newHandler().ServeHTTP(rr, req)
+
+	if rr.Header().Get("X-Affinity") != "" || rr.Header().Get("Set-Cookie") != "" {
+		t.Errorf("affinity headers set while disabled: %v", rr.Header())
+	}
+}
+
+func TestLogsStatsHandler_Affinity(t *testing.T) {
+	logger = NewAccessLogger(100)
+	withAffinityCookie(t, "app-1")
+	handler := newHandler()
+
+	for _, cookie := range []string{"", "SERVERID=app-1", "SERVERID=app-1", "SERVERID=app-1", "SERVERID=app-2"} {
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The stats request itself is a hit
+	req, _ := http.NewRequest("GET", "/logs/stats", nil)
+	req.Header.Set("Cookie", "SERVERID=app-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var response struct {
+		Total    int `json:"total"`
+		Affinity struct {
+			Hit      int     `json:"hit"`
+			Miss     int     `json:"miss"`
+			New      int     `json:"new"`
+			HitRate  float64 `json:"hit_rate"`
+			Instance string  `json:"instance"`
+		} `json:"affinity"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if response.Total != 6 || response.Affinity.Hit != 4 || response.Affinity.Miss != 1 || response.Affinity.New != 1 {
+		t.Errorf("unexpected stats: %+v", response)
+	}
+	if response.Affinity.HitRate != 0.8 || response.Affinity.Instance != "app-1" {
+		t.Errorf("unexpected stats: %+v", response)
+	}
+
+	logs := logger.GetLogs()
+	if logs[0].Affinity != "new" || !strings.Contains(rr.Body.String(), `"enabled":true`) {
+		t.Errorf("unexpected log entry: %+v", logs[0])
+	}
+}
+
+func TestLogsStatsHandler_Window(t *testing.T) {
+	saved := logger
+	logger = NewAccessLogger(3)
+	t.Cleanup(func() { logger = saved })
+
+	// Only the last 3 requests, including the stats request, are counted
+	for _, affinity := range []string{"hit", "hit", "miss", "miss", "new"} {
+		logger.Add(AccessLog{Affinity: affinity})
+	}
+	req, _ := http.NewRequest("GET", "/logs/stats", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(logsStatsHandler).ServeHTTP(rr, req)
+
+	var response struct {
+		Total    int    `json:"total"`
+		Window   int    `json:"window"`
+		FirstID  uint64 `json:"first_id"`
+		Since    string `json:"since"`
+		Affinity struct {
+			Hit  int `json:"hit"`
+			Miss int `json:"miss"`
+			New  int `json:"new"`
+		} `json:"affinity"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if response.Total != 3 || response.Window != 3 || response.FirstID != 4 {
+		t.Errorf("unexpected window: %+v", response)
+	}
+	if response.Affinity.Hit != 0 || response.Affinity.Miss != 1 || response.Affinity.New != 1 {
+		t.Errorf("unexpected stats: %+v", response)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// authRealm is the realm sent in authentication challenges
+const authRealm = "debug-httpd"
+
+// digestNonceLifetime is how long a Digest nonce stays valid
+const digestNonceLifetime = 5 * time.Minute
+
+// maxDigestBodySize bounds the request body hashed for qop=auth-int
+const maxDigestBodySize = 1 << 20
+
+// digestSecret signs Digest nonces so they can be verified without keeping
+// state
+var digestSecret = func() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}()
+
+// maskSecret hides a password or other secret completely
+func maskSecret(s string) string {
+	return strings.Repeat("*", len(s))
+}
+
+// maskToken hides a token, keeping the first few characters of long tokens
+// so they can still be told apart
+func maskToken(s string) string {
+	if len(s) < 20 {
+		return maskSecret(s)
+	}
+	return s[:4] + maskSecret(s[4:])
+}
+
+// describeAuthorization decodes an Authorization (or Proxy-Authorization)
+// header value with its secrets masked
+func describeAuthorization(value string) map[string]interface{} {
+	scheme, credentials, _ := strings.Cut(strings.TrimSpace(value), " ")
+	credentials = strings.TrimSpace(credentials)
+	result := map[string]interface{}{"scheme": scheme}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			result["error"] = "credentials are not valid base64"
+			break
+		}
+		user, pass, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			result["error"] = "credentials are missing the ':' separator"
+			break
+		}
+		result["username"] = user
+		result["password"] = maskSecret(pass)
+	case "bearer":
+		result["token"] = maskToken(credentials)
+		if decoded, err := decodeJWT(credentials); err == nil {
+			result["jwt"] = map[string]interface{}{
+				"header": decoded.header,
+				"claims": decoded.claims,
+			}
+		}
+	case "digest":
+		params := parseAuthParams(credentials)
+		if response, ok := params["response"]; ok {
+			params["response"] = maskToken(response)
+		}
+		result["params"] = params
+	default:
+		result["credentials"] = maskSecret(credentials)
+	}
+	return result
+}
+
+// maskAuthorizationHeaders returns a copy of h with credentials masked
+func maskAuthorizationHeaders(h http.Header) http.Header {
+	masked := h.Clone()
+	for _, name := range []string{"Authorization", "Proxy-Authorization"} {
+		for i, value := range masked[name] {
+			// Basic credentials contain the password, so none of them is shown
+			scheme, credentials, _ := strings.Cut(value, " ")
+			credentials = strings.TrimSpace(credentials)
+			if strings.EqualFold(scheme, "Basic") {
+				masked[name][i] = scheme + " " + maskSecret(credentials)
+			} else {
+				masked[name][i] = scheme + " " + maskToken(credentials)
+			}
+		}
+	}
+	return masked
+}
+
+// parseAuthParams parses comma separated auth-params such as
+// username="alice", qop=auth, nc=00000001
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[key] = value.String()
+	}
+}
+
+// writeAuthChallenge sends 401 with the given WWW-Authenticate challenge
+func writeAuthChallenge(w http.ResponseWriter, challenge, message string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"authenticated": false,
+		"error":         message,
+	})
+}
+
+// writeAuthenticated sends 200 with the authenticated identity
+func writeAuthenticated(w http.ResponseWriter, scheme string, identity map[string]interface{}) {
+	response := map[string]interface{}{
+		"authenticated": true,
+		"scheme":        scheme,
+	}
+	for key, value := range identity {
+		response[key] = value
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// basicAuthHandler handles /basic-auth/{user}/{pass} requests
+func basicAuthHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	user, pass, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/basic-auth/"), "/")
+	if !ok || user == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "user and password are required",
+			"example": "/basic-auth/alice/secret",
+		})
+		return
+	}
+
+	challenge := fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, authRealm)
+	gotUser, gotPass, present := r.BasicAuth()
+	if !present {
+		writeAuthChallenge(w, challenge, "missing Basic credentials")
+		return
+	}
+	if !secureCompare(gotUser, user) || !secureCompare(gotPass, pass) {
+		writeAuthChallenge(w, challenge, "invalid username or password")
+		return
+	}
+	writeAuthenticated(w, "Basic", map[string]interface{}{"user": user})
+}
+
+// bearerHandler handles /bearer requests. Any token is accepted unless the
+// token query parameter names the expected one.
+func bearerHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		writeAuthChallenge(w, fmt.Sprintf(`Bearer realm="%s"`, authRealm), "missing Bearer token")
+		return
+	}
+	if expected := r.URL.Query().Get("token"); expected != "" && !secureCompare(token, expected) {
+		writeAuthChallenge(w,
+			fmt.Sprintf(`Bearer realm="%s", error="invalid_token", error_description="The access token is invalid"`, authRealm),
+			"invalid token")
+		return
+	}
+	writeAuthenticated(w, "Bearer", map[string]interface{}{"token": maskToken(token)})
+}
+
+// digestHash returns the hash constructor for a Digest algorithm
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+func digestHex(newHash func() hash.Hash, s string) string {
+	h := newHash()
+	io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newDigestNonce returns a nonce made of the current time and its
+// signature
+func newDigestNonce() string {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, digestSecret)
+	io.WriteString(mac, timestamp)
+	return base64.RawURLEncoding.EncodeToString([]byte(timestamp + ":" + hex.EncodeToString(mac.Sum(nil))))
+}
+
+// checkDigestNonce verifies a nonce from newDigestNonce, reporting whether
+// it was issued by this server and whether it has expired
+func checkDigestNonce(nonce string) (valid, stale bool) {
+	decoded, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil {
+		return false, false
+	}
+	timestamp, signature, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false, false
+	}
+	mac := hmac.New(sha256.New, digestSecret)
+	io.WriteString(mac, timestamp)
+	if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		return false, false
+	}
+	issued, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false, false
+	}
+	return true, time.Since(time.Unix(issued, 0)) > digestNonceLifetime
+}
+
+// digestAuthHandler handles /digest-auth/{qop}/{user}/{pass}[/{algorithm}]
+// requests (RFC 7616)
+func digestAuthHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/digest-auth/"), "/")
+	if len(segments) != 3 && len(segments) != 4 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "qop, user and password are required",
+			"example": "/digest-auth/auth/alice/secret or /digest-auth/auth-int/alice/secret/SHA-256",
+		})
+		return
+	}
+	qop, user, pass := segments[0], segments[1], segments[2]
+	algorithm := "MD5"
+	if len(segments) == 4 {
+		algorithm = strings.ToUpper(segments[3])
+	}
+	newHash := digestHash(algorithm)
+	if (qop != "auth" && qop != "auth-int") || newHash == nil || user == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "qop must be auth or auth-int and algorithm must be MD5, MD5-sess, SHA-256 or SHA-256-sess",
+		})
+		return
+	}
+
+	challenge := func(stale bool) string {
+		c := fmt.Sprintf(`Digest realm="%s", qop="%s", algorithm=%s, nonce="%s", opaque="%s"`,
+			authRealm, qop, algorithm, newDigestNonce(), digestHex(md5.New, authRealm))
+		if stale {
+			c += ", stale=true"
+		}
+		return c
+	}
+
+	scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		writeAuthChallenge(w, challenge(false), "missing Digest credentials")
+		return
+	}
+	params := parseAuthParams(credentials)
+
+	valid, stale := checkDigestNonce(params["nonce"])
+	switch {
+	case !valid:
+		writeAuthChallenge(w, challenge(false), "unknown nonce")
+		return
+	case stale:
+		writeAuthChallenge(w, challenge(true), "nonce has expired")
+		return
+	case params["qop"] != qop:
+		writeAuthChallenge(w, challenge(false), fmt.Sprintf("qop must be %s", qop))
+		return
+	}
+	if err := checkDigestURI(params["uri"], r); err != nil {
+		writeAuthChallenge(w, challenge(false), err.Error())
+		return
+	}
+
+	ha1 := digestHex(newHash, fmt.Sprintf("%s:%s:%s", user, authRealm, pass))
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = digestHex(newHash, fmt.Sprintf("%s:%s:%s", ha1, params["nonce"], params["cnonce"]))
+	}
+	ha2 := digestHex(newHash, fmt.Sprintf("%s:%s", r.Method, params["uri"]))
+	if qop == "auth-int" {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDigestBodySize))
+		if err != nil {
+			writeAuthChallenge(w, challenge(false), fmt.Sprintf("auth-int body must not exceed %d bytes", maxDigestBodySize))
+			return
+		}
+		ha2 = digestHex(newHash, fmt.Sprintf("%s:%s:%s", r.Method, params["uri"], digestHex(newHash, string(body))))
+	}
+	expected := digestHex(newHash, fmt.Sprintf("%s:%s:%s:%s:%s:%s",
+		ha1, params["nonce"], params["nc"], params["cnonce"], qop, ha2))
+
+	if !secureCompare(params["username"], user) || !secureCompare(params["response"], expected) {
+		writeAuthChallenge(w, challenge(false), "invalid username or password")
+		return
+	}
+	identity := map[string]interface{}{
+		"user":      user,
+		"qop":       qop,
+		"algorithm": algorithm,
+		"uri":       params["uri"],
+	}
+	if params["uri"] != r.RequestURI {
+		// e.g. a proxy normalized the path or reordered the query
+		identity["request_uri"] = r.RequestURI
+	}
+	writeAuthenticated(w, "Digest", identity)
+}
+
+// checkDigestURI compares the uri of Digest credentials with the request.
+// The path and query are compared after parsing, so that a request whose
+// URI was normalized by a proxy (percent-encoding, query order) still
+// matches. The error says which part differs.
+func checkDigestURI(uri string, r *http.Request) error {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return fmt.Errorf("invalid uri %q", uri)
+	}
+	if u.Path != r.URL.Path {
+		return fmt.Errorf("uri path %q does not match the request path %q", u.Path, r.URL.Path)
+	}
+	if !reflect.DeepEqual(u.Query(), r.URL.Query()) {
+		return fmt.Errorf("uri query %q does not match the request query %q", u.RawQuery, r.URL.RawQuery)
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBytesSize is the largest body /bytes will generate
+const maxBytesSize = 1 << 40
+
+// bytesBufferSize is the size of each write while generating a body
+const bytesBufferSize = 64 << 10
+
+// TransferLog records the size and speed of a large request or response body
+type TransferLog struct {
+	Direction      string `json:"direction"`
+	Bytes          int64  `json:"bytes"`
+	Complete       bool   `json:"complete"`
+	Duration       string `json:"duration"`
+	BytesPerSecond int64  `json:"bytes_per_second"`
+	Throughput     string `json:"throughput"`
+}
+
+// newTransferLog summarizes a transfer of n bytes that started at start
+func newTransferLog(direction string, n int64, complete bool, start time.Time) *TransferLog {
+	elapsed := time.Since(start)
+	var rate int64
+	if elapsed > 0 {
+		rate = int64(float64(n) / elapsed.Seconds())
+	}
+	return &TransferLog{
+		Direction:      direction,
+		Bytes:          n,
+		Complete:       complete,
+		Duration:       elapsed.String(),
+		BytesPerSecond: rate,
+		Throughput:     formatByteSize(rate) + "/s",
+	}
+}
+
+// RangeLog records a Range request and how it was answered
+type RangeLog struct {
+	Header       string   `json:"header"`
+	Ranges       []string `json:"ranges"`
+	IfRange      string   `json:"if_range,omitempty"`
+	Status       int      `json:"status"`
+	ContentRange string   `json:"content_range,omitempty"`
+}
+
+// newRangeLog describes the Range header of r, or returns nil if there is
+// none
+func newRangeLog(r *http.Request) *RangeLog {
+	header := r.Header.Get("Range")
+	if header == "" {
+		return nil
+	}
+	var ranges []string
+	for _, spec := range strings.Split(strings.TrimPrefix(header, "bytes="), ",") {
+		ranges = append(ranges, strings.TrimSpace(spec))
+	}
+	return &RangeLog{Header: header, Ranges: ranges, IfRange: r.Header.Get("If-Range")}
+}
+
+// countingWriter records the status and counts the body bytes written
+type countingWriter struct {
+	statusRecorder
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.statusRecorder.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// byteSizeUnits maps lower-cased size suffixes to multipliers. Single
+// letter suffixes are binary, as in dd and curl.
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
+
+// parseByteSize parses sizes such as 512, 64KB, 1.5MiB or 1GiB
+func parseByteSize(s string) (int64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	number, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	multiplier, ok := byteSizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit %q", s[i:])
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	size := value * float64(multiplier)
+	if size > maxBytesSize {
+		return 0, fmt.Errorf("size exceeds maximum of %s", formatByteSize(maxBytesSize))
+	}
+	return int64(size), nil
+}
+
+// formatByteSize formats n using binary units, e.g. 1.50 MiB
+func formatByteSize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.2f %s", value, units[i])
+}
+
+// byteContent is a seekable, generated body of a fixed size. Seeded content
+// is computed from the offset, so any part of it can be read without
+// generating what comes before.
+type byteContent struct {
+	zero   bool
+	seed   uint64
+	size   int64
+	offset int64
+}
+
+// newByteContent creates content of the given size for mode "zero",
+// "random" or "seeded"
+func newByteContent(mode string, seed uint64, size int64) (*byteContent, error) {
+	switch mode {
+	case "zero":
+		return &byteContent{zero: true, size: size}, nil
+	case "random":
+		var b [8]byte
+		rand.Read(b[:])
+		return &byteContent{seed: binary.LittleEndian.Uint64(b[:]), size: size}, nil
+	case "seeded":
+		return &byteContent{seed: seed, size: size}, nil
+	}
+	return nil, fmt.Errorf("unknown mode %q", mode)
+}
+
+// etag returns an entity tag identifying deterministic content
+func (c *byteContent) etag() string {
+	if c.zero {
+		return fmt.Sprintf(`"zero-%d"`, c.size)
+	}
+	return fmt.Sprintf(`"seeded-%d-%d"`, c.seed, c.size)
+}
+
+// block returns the 8 bytes of content starting at offset 8*i
+func (c *byteContent) block(i int64) uint64 {
+	// splitmix64
+	z := c.seed + uint64(i+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// ReadAt implements io.ReaderAt
+func (c *byteContent) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	if remaining := c.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	if c.zero {
+		clear(p)
+	} else {
+		var buf [8]byte
+		for n := 0; n < len(p); {
+			pos := off + int64(n)
+			if pos%8 == 0 && len(p)-n >= 8 {
+				binary.LittleEndian.PutUint64(p[n:], c.block(pos/8))
+				n += 8
+				continue
+			}
+			binary.LittleEndian.PutUint64(buf[:], c.block(pos/8))
+			n += copy(p[n:], buf[pos%8:])
+		}
+	}
+
+	if off+int64(len(p)) == c.size {
+		return len(p), io.EOF
+	}
+	return len(p), nil
+}
+
+// Read implements io.Reader
+func (c *byteContent) Read(p []byte) (int, error) {
+	n, err := c.ReadAt(p, c.offset)
+	c.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (c *byteContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+// pacer spaces a transfer out to rate bytes per second (0 is unlimited).
+// It moves at least ~20 chunks per second so the rate stays smooth.
+type pacer struct {
+	ctx   context.Context
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+// chunk returns how many of size bytes to transfer before the next wait,
+// starting the clock on the first call
+func (p *pacer) chunk(size int) int {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	if p.rate <= 0 {
+		return size
+	}
+	return int(min(int64(size), max(1, p.rate/20)))
+}
+
+// wait counts n bytes transferred and waits until the total no longer
+// exceeds the rate, returning false if ctx is cancelled first
+func (p *pacer) wait(n int) bool {
+	p.n += int64(n)
+	if p.rate <= 0 {
+		return true
+	}
+	due := time.Duration(float64(p.n) / float64(p.rate) * float64(time.Second))
+	return waitOrDone(p.ctx, due-time.Since(p.start))
+}
+
+// copyWithRate copies src to w, flushing after each write and sleeping as
+// needed to stay under rate bytes per second (0 is unlimited)
+func copyWithRate(ctx context.Context, w http.ResponseWriter, src io.Reader, rate int64) (int64, error) {
+	pace := pacer{ctx: ctx, rate: rate}
+	buf := make([]byte, pace.chunk(bytesBufferSize))
+	rc := http.NewResponseController(w)
+
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return pace.n, err
+			}
+			if rate > 0 {
+				rc.Flush()
+			}
+			if !pace.wait(n) {
+				return pace.n, ctx.Err()
+			}
+		}
+		if readErr == io.EOF {
+			return pace.n, nil
+		}
+		if readErr != nil {
+			return pace.n, readErr
+		}
+	}
+}
+
+// bytesHandler handles /bytes/{size} requests by generating a body of the
+// requested size without buffering it
+func bytesHandler(w http.ResponseWriter, r *http.Request) {
+	logID := logAccess(r)
+	query := r.URL.Query()
+
+	size, err := parseByteSize(strings.TrimPrefix(r.URL.Path, "/bytes/"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   err.Error(),
+			"example": "/bytes/1GiB?mode=random (units: B, KB, KiB, MB, MiB, GB, GiB, TB, TiB)",
+		})
+		return
+	}
+
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = "random"
+	}
+	var seed uint64
+	if seedStr := query.Get("seed"); seedStr != "" {
+		seed, err = strconv.ParseUint(seedStr, 10, 64)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "seed must be an unsigned integer",
+			})
+			return
+		}
+		if query.Get("mode") == "" {
+			mode = "seeded"
+		}
+	}
+	content, err := newByteContent(mode, seed, size)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("%v (supported: random, zero, seeded)", err),
+		})
+		return
+	}
+
+	var rate int64
+	if rateStr := query.Get("rate"); rateStr != "" {
+		rate, err = parseByteSize(strings.TrimSuffix(rateStr, "/s"))
+		if err != nil || rate <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "rate must be a positive size per second",
+				"example": "/bytes/100MiB?rate=10MiB",
+			})
+			return
+		}
+	}
+
+	chunked := query.Get("chunked") == "true"
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if !content.zero {
+		w.Header().Set("X-Content-Seed", strconv.FormatUint(content.seed, 10))
+	}
+
+	// Only content that is the same on every request can be served in parts
+	if mode != "random" && !chunked {
+		serveByteContent(w, r, logID, content, rate)
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "none")
+	if chunked {
+		w.Header().Set("Trailer", "X-Bytes-Sent, X-Duration, X-Throughput")
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	start := time.Now()
+	written, _ := copyWithRate(r.Context(), w, content, rate)
+	transfer := newTransferLog("sent", written, written == size, start)
+
+	if chunked {
+		w.Header().Set("X-Bytes-Sent", strconv.FormatInt(transfer.Bytes, 10))
+		w.Header().Set("X-Duration", transfer.Duration)
+		w.Header().Set("X-Throughput", transfer.Throughput)
+	}
+	setSpanAttribute(r.Context(), "debug_httpd.transfer.bytes", written)
+
+	// Random content ignores Range and is always sent in full
+	rangeLog := newRangeLog(r)
+	if rangeLog != nil {
+		rangeLog.Status = http.StatusOK
+	}
+	logger.Update(logID, func(log *AccessLog) {
+		log.Transfer = transfer
+		log.Range = rangeLog
+	})
+}
+
+// serveByteContent serves deterministic content with support for Range,
+// If-Range and conditional requests, recording the range in the access log
+func serveByteContent(w http.ResponseWriter, r *http.Request, logID uint64, content *byteContent, rate int64) {
+	w.Header().Set("ETag", content.etag())
+
+	cw := &countingWriter{statusRecorder: statusRecorder{ResponseWriter: w}}
+	var out http.ResponseWriter = cw
+	if rate > 0 {
+		out = &throttledWriter{ResponseWriter: cw, pace: pacer{ctx: r.Context(), rate: rate}}
+	}
+
+	start := time.Now()
+	http.ServeContent(out, r, "", time.Time{}, content)
+
+	rangeLog := newRangeLog(r)
+	if rangeLog != nil {
+		rangeLog.Status = cw.status
+		rangeLog.ContentRange = w.Header().Get("Content-Range")
+	}
+	var transfer *TransferLog
+	if r.Method != http.MethodHead {
+		expected, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+		transfer = newTransferLog("sent", cw.n, err == nil && cw.n == expected, start)
+		setSpanAttribute(r.Context(), "debug_httpd.transfer.bytes", cw.n)
+	}
+	logger.Update(logID, func(log *AccessLog) {
+		log.Transfer = transfer
+		log.Range = rangeLog
+	})
+}
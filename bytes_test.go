@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"10B", 10},
+		{"64KB", 64000},
+		{"64KiB", 65536},
+		{"64k", 65536},
+		{"1.5MiB", 1572864},
+		{"2MB", 2000000},
+		{"1GiB", 1 << 30},
+		{"1gb", 1000000000},
+		{"1TiB", 1 << 40},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.input)
+		if err != nil {
+			t.Errorf("parseByteSize(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "abc", "10XB", "2TiB", "1..5MiB"} {
+		if _, err := parseByteSize(input); err == nil {
+			t.Errorf("parseByteSize(%q) expected error", input)
+		}
+	}
+}
+
+func TestByteContent_ReadAt(t *testing.T) {
+	content, _ := newByteContent("seeded", 42, 1000)
+	all, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1000 {
+		t.Fatalf("unexpected length: %d", len(all))
+	}
+
+	// Any slice of the content matches the sequential read
+	for _, off := range []int64{0, 3, 8, 517, 990} {
+		buf := make([]byte, 13)
+		n, _ := content.ReadAt(buf, off)
+		if !bytes.Equal(buf[:n], all[off:off+int64(n)]) {
+			t.Errorf("ReadAt(%d) does not match the sequential content", off)
+		}
+	}
+
+	// The same seed always produces the same content
+	again, _ := newByteContent("seeded", 42, 1000)
+	if b, _ := io.ReadAll(again); !bytes.Equal(b, all) {
+		t.Error("seeded content is not deterministic")
+	}
+	other, _ := newByteContent("seeded", 43, 1000)
+	if b, _ := io.ReadAll(other); bytes.Equal(b, all) {
+		t.Error("different seeds produced the same content")
+	}
+}
+
+func TestBytesHandler(t *testing.T) {
+	logger = NewAccessLogger(100)
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/bytes/1KiB?mode=zero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.ContentLength != 1024 {
+		t.Errorf("unexpected Content-Length: %v", resp.ContentLength)
+	}
+	if !bytes.Equal(body, make([]byte, 1024)) {
+		t.Error("zero mode returned non-zero bytes")
+	}
+
+	logs := logger.GetLogs()
+	transfer := logs[len(logs)-1].Transfer
+	if transfer == nil || transfer.Bytes != 1024 || !transfer.Complete || transfer.Direction != "sent" {
+		t.Errorf("unexpected transfer log: %+v", transfer)
+	}
+}
+
+func TestBytesHandler_ChunkedWithRate(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/bytes/20KB?seed=7&chunked=true&rate=100KB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("rate limit not applied: %v", elapsed)
+	}
+	if resp.ContentLength != -1 {
+		t.Errorf("expected chunked response, got Content-Length %v", resp.ContentLength)
+	}
+	if resp.Header.Get("X-Content-Seed") != "7" {
+		t.Errorf("unexpected X-Content-Seed: %v", resp.Header.Get("X-Content-Seed"))
+	}
+
+	content, _ := newByteContent("seeded", 7, 20000)
+	want, _ := io.ReadAll(content)
+	if !bytes.Equal(body, want) {
+		t.Error("seeded body does not match the generated content")
+	}
+	if got := resp.Trailer.Get("X-Bytes-Sent"); got != strconv.Itoa(20000) {
+		t.Errorf("unexpected X-Bytes-Sent trailer: %v", got)
+	}
+	if resp.Trailer.Get("X-Throughput") == "" {
+		t.Error("missing X-Throughput trailer")
+	}
+}
+
+func TestBytesHandler_InvalidParams(t *testing.T) {
+	tests := []string{
+		"/bytes/",
+		"/bytes/abc",
+		"/bytes/2TiB",
+		"/bytes/1KiB?mode=ones",
+		"/bytes/1KiB?seed=-1",
+		"/bytes/1KiB?rate=0",
+	}
+
+	for _, path := range tests {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(bytesHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, status, http.StatusBadRequest)
+		}
+	}
+}
+
+// requestBytes runs bytesHandler for path with the given request headers
+func requestBytes(t *testing.T, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(bytesHandler)
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBytesHandler_Range(t *testing.T) {
+	logger = NewAccessLogger(100)
+	content, _ := newByteContent("seeded", 7, 1024)
+	full, _ := io.ReadAll(content)
+
+	rr := requestBytes(t, "/bytes/1KiB?seed=7", nil)
+	if rr.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("unexpected Accept-Ranges: %v", rr.Header().Get("Accept-Ranges"))
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" || !bytes.Equal(rr.Body.Bytes(), full) {
+		t.Fatalf("unexpected full response: ETag %q, %d bytes", etag, rr.Body.Len())
+	}
+
+	rr = requestBytes(t, "/bytes/1KiB?seed=7", map[string]string{"Range": "bytes=100-199"})
+	if status := rr.Code; status != http.StatusPartialContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusPartialContent)
+	}
+	if got := rr.Header().Get("Content-Range"); got != "bytes 100-199/1024" {
+		t.Errorf("unexpected Content-Range: %v", got)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), full[100:200]) {
+		t.Error("range does not match the full content")
+	}
+
+	logs := logger.GetLogs()
+	log := logs[len(logs)-1]
+	if log.Range == nil || log.Range.Status != http.StatusPartialContent || log.Range.ContentRange != "bytes 100-199/1024" {
+		t.Errorf("unexpected range log: %+v", log.Range)
+	}
+	if log.Transfer == nil || log.Transfer.Bytes != 100 || !log.Transfer.Complete {
+		t.Errorf("unexpected transfer log: %+v", log.Transfer)
+	}
+
+	// Several ranges are sent as multipart/byteranges
+	rr = requestBytes(t, "/bytes/1KiB?seed=7", map[string]string{"Range": "bytes=0-9,-10"})
+	if status := rr.Code; status != http.StatusPartialContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusPartialContent)
+	}
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "multipart/byteranges") {
+		t.Errorf("unexpected Content-Type: %v", got)
+	}
+
+	rr = requestBytes(t, "/bytes/1KiB?seed=7", map[string]string{"Range": "bytes=2000-"})
+	if status := rr.Code; status != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestedRangeNotSatisfiable)
+	}
+
+	// If-Range only applies the range while the ETag matches
+	rr = requestBytes(t, "/bytes/1KiB?seed=7", map[string]string{"Range": "bytes=0-9", "If-Range": etag})
+	if status := rr.Code; status != http.StatusPartialContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusPartialContent)
+	}
+	rr = requestBytes(t, "/bytes/1KiB?seed=8", map[string]string{"Range": "bytes=0-9", "If-Range": etag})
+	if status := rr.Code; status != http.StatusOK || rr.Body.Len() != 1024 {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestBytesHandler_RangeRandom(t *testing.T) {
+	logger = NewAccessLogger(100)
+	rr := requestBytes(t, "/bytes/1KiB", map[string]string{"Range": "bytes=0-9"})
+
+	if status := rr.Code; status != http.StatusOK || rr.Body.Len() != 1024 {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if rr.Header().Get("Accept-Ranges") != "none" {
+		t.Errorf("unexpected Accept-Ranges: %v", rr.Header().Get("Accept-Ranges"))
+	}
+	logs := logger.GetLogs()
+	if r := logs[len(logs)-1].Range; r == nil || r.Status != http.StatusOK {
+		t.Errorf("unexpected range log: %+v", r)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheSeconds is the longest max-age /cache accepts (one year)
+const maxCacheSeconds = 365 * 24 * 60 * 60
+
+// maxCacheResources caps the number of resources /cache tracks
+const maxCacheResources = 1000
+
+// cacheResource tracks the requests for a /cache resource that reached the
+// origin
+type cacheResource struct {
+	LastModified time.Time  `json:"last_modified"`
+	Requests     int        `json:"requests"`
+	NotModified  int        `json:"not_modified"`
+	EvictedAt    *time.Time `json:"evicted_at,omitempty"`
+	lastRequest  time.Time
+}
+
+// cacheResources holds the /cache resources by path and query string, and
+// when the evicted ones were forgotten
+var cacheResources = struct {
+	sync.Mutex
+	resources map[string]*cacheResource
+	evicted   map[string]time.Time
+}{resources: map[string]*cacheResource{}, evicted: map[string]time.Time{}}
+
+// recordCacheRequest counts a request for the resource and returns a copy
+// of its state. Last-Modified is the time of the first request, and
+// isNotModified decides whether the request is answered with 304. When
+// maxCacheResources are tracked, the least recently requested one is
+// forgotten, and EvictedAt tells when if it is requested again.
+func recordCacheRequest(key string, now time.Time, isNotModified func(lastModified time.Time) bool) (cacheResource, bool) {
+	cacheResources.Lock()
+	defer cacheResources.Unlock()
+
+	resource, ok := cacheResources.resources[key]
+	if !ok {
+		if len(cacheResources.resources) >= maxCacheResources {
+			evictCacheResource(now)
+		}
+		resource = &cacheResource{LastModified: now.UTC().Truncate(time.Second)}
+		if evictedAt, ok := cacheResources.evicted[key]; ok {
+			resource.EvictedAt = &evictedAt
+			delete(cacheResources.evicted, key)
+		}
+		cacheResources.resources[key] = resource
+	}
+	notModified := isNotModified(resource.LastModified)
+	resource.Requests++
+	if notModified {
+		resource.NotModified++
+	}
+	resource.lastRequest = now
+	return *resource, notModified
+}
+
+// evictCacheResource removes the least recently requested resource and
+// remembers when, forgetting the earliest eviction beyond maxCacheResources.
+// The caller must hold the lock.
+func evictCacheResource(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, resource := range cacheResources.resources {
+		if oldestKey == "" || resource.lastRequest.Before(oldest) {
+			oldestKey, oldest = key, resource.lastRequest
+		}
+	}
+	delete(cacheResources.resources, oldestKey)
+
+	if len(cacheResources.evicted) >= maxCacheResources {
+		var earliestKey string
+		var earliest time.Time
+		for key, evictedAt := range cacheResources.evicted {
+			if earliestKey == "" || evictedAt.Before(earliest) {
+				earliestKey, earliest = key, evictedAt
+			}
+		}
+		delete(cacheResources.evicted, earliestKey)
+	}
+	cacheResources.evicted[oldestKey] = now.UTC()
+}
+
+// cacheControl builds the Cache-Control header for /cache from the query
+func cacheControl(seconds int, r *http.Request) (string, error) {
+	query := r.URL.Query()
+	if raw := query.Get("cache_control"); raw != "" {
+		return raw, nil
+	}
+
+	directives := []string{"public"}
+	if query.Get("private") == "true" {
+		directives[0] = "private"
+	}
+	directives = append(directives, fmt.Sprintf("max-age=%d", seconds))
+	for _, name := range []string{"s_maxage", "stale_while_revalidate", "stale_if_error"} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > maxCacheSeconds {
+			return "", fmt.Errorf("%s must be between 0 and %d", name, maxCacheSeconds)
+		}
+		directives = append(directives, fmt.Sprintf("%s=%d", strings.ReplaceAll(name, "_", "-"), n))
+	}
+	if query.Get("immutable") == "true" {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", "), nil
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison required for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheHandler handles /cache/{seconds} requests with cacheable responses.
+// Every request that reaches the origin is counted per resource.
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	const example = "/cache/60?vary=Accept-Language&etag=none"
+	seconds, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/cache/"))
+	if err != nil || seconds < 0 || seconds > maxCacheSeconds {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   fmt.Sprintf("seconds must be between 0 and %d", maxCacheSeconds),
+			"example": example,
+		})
+		return
+	}
+	cc, err := cacheControl(seconds, r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   err.Error(),
+			"example": example,
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	key := r.URL.Path
+	if len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+
+	// Each combination of the Vary headers is a variant with its own ETag.
+	// The body differs on every request (origin_requests, generated_at), so
+	// the ETag is weak: it only says the representations are equivalent.
+	variant := map[string]string{}
+	var varyNames []string
+	for _, name := range strings.Split(query.Get("vary"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			name = http.CanonicalHeaderKey(name)
+			varyNames = append(varyNames, name)
+			variant[name] = r.Header.Get(name)
+		}
+	}
+	sort.Strings(varyNames)
+	h := fnv.New64a()
+	fmt.Fprint(h, key)
+	for _, name := range varyNames {
+		fmt.Fprintf(h, "\n%s: %s", name, variant[name])
+	}
+	etag := fmt.Sprintf(`W/"%016x"`, h.Sum64())
+	if query.Get("etag") == "none" {
+		etag = ""
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 9110
+	// section 13.2.2)
+	now := time.Now()
+	resource, notModified := recordCacheRequest(key, now, func(lastModified time.Time) bool {
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+			return etag != "" && etagMatches(ifNoneMatch, etag)
+		}
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+			return !lastModified.After(since)
+		}
+		return false
+	})
+
+	header := w.Header()
+	header.Set("Cache-Control", cc)
+	header.Set("Last-Modified", resource.LastModified.Format(http.TimeFormat))
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if len(varyNames) > 0 {
+		// Add, so that the Vary set by other middleware (e.g., Origin) is kept
+		header.Add("Vary", strings.Join(varyNames, ", "))
+	}
+	header.Set("X-Origin-Requests", strconv.Itoa(resource.Requests))
+	if resource.EvictedAt != nil {
+		header.Set("X-Origin-Requests-Reset", resource.EvictedAt.Format(http.TimeFormat))
+	}
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	response := map[string]interface{}{
+		"resource":        key,
+		"cache_control":   cc,
+		"last_modified":   resource.LastModified.Format(http.TimeFormat),
+		"origin_requests": resource.Requests,
+		"not_modified":    resource.NotModified,
+		"generated_at":    now.Format(time.RFC3339Nano),
+	}
+	if etag != "" {
+		response["etag"] = etag
+	}
+	if resource.EvictedAt != nil {
+		response["evicted_at"] = resource.EvictedAt.Format(time.RFC3339Nano)
+	}
+	if len(variant) > 0 {
+		response["vary"] = variant
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// cacheStatsHandler handles /cache/stats requests by listing the origin
+// requests of every /cache resource
+func cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	cacheResources.Lock()
+	resources := make(map[string]cacheResource, len(cacheResources.resources))
+	for key, resource := range cacheResources.resources {
+		resources[key] = *resource
+	}
+	cacheResources.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resources": resources,
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestCache runs cacheHandler for path with the given request headers
+func requestCache(t *testing.T, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cacheHandler)
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCacheHandler(t *testing.T) {
+	rr := requestCache(t, "/cache/60?test=basic", nil)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != "public, max-age=60" {
+		t.Errorf("unexpected Cache-Control: %v", got)
+	}
+	etag, lastModified := rr.Header().Get("ETag"), rr.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("missing validators: %v", rr.Header())
+	}
+
+	// Revalidation with either validator
+	rr = requestCache(t, "/cache/60?test=basic", map[string]string{"If-None-Match": `"other", ` + etag})
+	if status := rr.Code; status != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotModified)
+	}
+	rr = requestCache(t, "/cache/60?test=basic", map[string]string{"If-Modified-Since": lastModified})
+	if status := rr.Code; status != http.StatusNotModified {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotModified)
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	rr = requestCache(t, "/cache/60?test=basic", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified})
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if response["origin_requests"] != float64(4) || response["not_modified"] != float64(2) {
+		t.Errorf("unexpected counters: %v", response)
+	}
+	if rr.Header().Get("X-Origin-Requests") != "4" {
+		t.Errorf("unexpected X-Origin-Requests: %v", rr.Header().Get("X-Origin-Requests"))
+	}
+
+	// An old If-Modified-Since gets the full response
+	old := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if status := requestCache(t, "/cache/60?test=basic", map[string]string{"If-Modified-Since": old}).Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestCacheHandler_Vary(t *testing.T) {
+	en := requestCache(t, "/cache/60?vary=accept-language", map[string]string{"Accept-Language": "en"})
+	ja := requestCache(t, "/cache/60?vary=accept-language", map[string]string{"Accept-Language": "ja"})
+
+	if got := en.Header().Get("Vary"); got != "Accept-Language" {
+		t.Errorf("unexpected Vary: %v", got)
+	}
+	if en.Header().Get("ETag") == ja.Header().Get("ETag") {
+		t.Error("variants have the same ETag")
+	}
+
+	// The ETag of one variant does not validate another
+	rr := requestCache(t, "/cache/60?vary=accept-language", map[string]string{"Accept-Language": "ja", "If-None-Match": en.Header().Get("ETag")})
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestCacheHandler_VaryWithCORS(t *testing.T) {
+	withCORSConfig(t, CORSPolicy{AllowOrigins: []string{"https://app.example.com"}}, "")
+	handler := newHandler()
+
+	req, _ := http.NewRequest("GET", "/cache/60?vary=accept-language", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if got := strings.Join(rr.Header().Values("Vary"), ", "); got != "Origin, Accept-Language" {
+		t.Errorf("unexpected Vary: %v", got)
+	}
+}
+
+func TestCacheHandler_Eviction(t *testing.T) {
+	cacheResources.Lock()
+	original, originalEvicted := cacheResources.resources, cacheResources.evicted
+	cacheResources.resources = map[string]*cacheResource{}
+	cacheResources.evicted = map[string]time.Time{}
+	cacheResources.Unlock()
+	t.Cleanup(func() {
+		cacheResources.Lock()
+		cacheResources.resources, cacheResources.evicted = original, originalEvicted
+		cacheResources.Unlock()
+	})
+
+	first := requestCache(t, "/cache/60?test=eviction", nil).Header().Get("Last-Modified")
+	for i := 0; i < maxCacheResources; i++ {
+		// Keep the first resource recently requested while others are added
+		if i%100 == 0 {
+			requestCache(t, "/cache/60?test=eviction", nil)
+		}
+		requestCache(t, fmt.Sprintf("/cache/60?test=eviction-%d", i), nil)
+	}
+
+	cacheResources.Lock()
+	count := len(cacheResources.resources)
+	cacheResources.Unlock()
+	if count != maxCacheResources {
+		t.Errorf("tracking %d resources, want %d", count, maxCacheResources)
+	}
+	rr := requestCache(t, "/cache/60?test=eviction", nil)
+	if got := rr.Header().Get("Last-Modified"); got != first {
+		t.Errorf("Last-Modified was reset: got %v want %v", got, first)
+	}
+	if got := rr.Header().Get("X-Origin-Requests"); got != "12" {
+		t.Errorf("unexpected X-Origin-Requests: %v", got)
+	}
+	if got := rr.Header().Get("X-Origin-Requests-Reset"); got != "" {
+		t.Errorf("unexpected X-Origin-Requests-Reset: %v", got)
+	}
+
+	// The least recently requested resource was forgotten, and says so when
+	// it is requested again
+	rr = requestCache(t, "/cache/60?test=eviction-0", nil)
+	if got := rr.Header().Get("X-Origin-Requests"); got != "1" {
+		t.Errorf("unexpected X-Origin-Requests: %v", got)
+	}
+	if got := rr.Header().Get("X-Origin-Requests-Reset"); got == "" {
+		t.Error("missing X-Origin-Requests-Reset for an evicted resource")
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if _, ok := response["evicted_at"]; !ok {
+		t.Errorf("response missing evicted_at: %v", response)
+	}
+
+	// Later requests still tell that the count restarted
+	reset := rr.Header().Get("X-Origin-Requests-Reset")
+	rr = requestCache(t, "/cache/60?test=eviction-0", nil)
+	if got := rr.Header().Get("X-Origin-Requests"); got != "2" {
+		t.Errorf("unexpected X-Origin-Requests: %v", got)
+	}
+	if got := rr.Header().Get("X-Origin-Requests-Reset"); got != reset {
+		t.Errorf("X-Origin-Requests-Reset got %v want %v", got, reset)
+	}
+}
+
+func TestCacheHandler_Directives(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected int
+		want     string
+	}{
+		{"/cache/0", http.StatusOK, "public, max-age=0"},
+		{"/cache/300?private=true&immutable=true", http.StatusOK, "private, max-age=300, immutable"},
+		{"/cache/60?s_maxage=600&stale_while_revalidate=30&stale_if_error=86400", http.StatusOK, "public, max-age=60, s-maxage=600, stale-while-revalidate=30, stale-if-error=86400"},
+		{"/cache/60?cache_control=no-store", http.StatusOK, "no-store"},
+		{"/cache/abc", http.StatusBadRequest, ""},
+		{"/cache/-1", http.StatusBadRequest, ""},
+		{"/cache/60?s_maxage=x", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		rr := requestCache(t, tt.path, nil)
+		if status := rr.Code; status != tt.expected {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.path, status, tt.expected)
+		}
+		if got := rr.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control got %q want %q", tt.path, got, tt.want)
+		}
+	}
+
+	// The body changes on every request, so the ETag is always weak
+	if etag := requestCache(t, "/cache/60", nil).Header().Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("expected a weak ETag, got %v", etag)
+	}
+	if etag := requestCache(t, "/cache/60?etag=none", nil).Header().Get("ETag"); etag != "" {
+		t.Errorf("expected no ETag, got %v", etag)
+	}
+}
+
+func TestCacheStatsHandler(t *testing.T) {
+	requestCache(t, "/cache/60?test=stats", nil)
+	requestCache(t, "/cache/60?test=stats", nil)
+
+	req, _ := http.NewRequest("GET", "/cache/stats", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cacheStatsHandler)
+	handler.ServeHTTP(rr, req)
+
+	var response struct {
+		Resources map[string]cacheResource `json:"resources"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if got := response.Resources["/cache/60?test=stats"].Requests; got != 2 {
+		t.Errorf("unexpected request count: %v", got)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxChainHops is the maximum number of hops a single /chain request may visit
+const maxChainHops = 10
+
+// chainHandler handles /chain?hops=host:port,host:port requests.
+// It records this hop, forwards the request to the first remaining hop and
+// nests the downstream response in its own.
+func chainHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+	startTime := time.Now()
+
+	var hops []string
+	for _, hop := range strings.Split(r.URL.Query().Get("hops"), ",") {
+		if hop = strings.TrimSpace(hop); hop != "" {
+			hops = append(hops, hop)
+		}
+	}
+	if len(hops) > maxChainHops {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "too many hops",
+			"max_hops": maxChainHops,
+		})
+		return
+	}
+
+	hop := 0
+	if hopStr := r.URL.Query().Get("hop"); hopStr != "" {
+		var err error
+		hop, err = strconv.Atoi(hopStr)
+		if err != nil || hop < 0 || hop > maxChainHops {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("hop must be between 0 and %d", maxChainHops),
+			})
+			return
+		}
+	}
+
+	timeout, err := parseProbeTimeout(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	clientAddress, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	response := map[string]interface{}{
+		"hop":              hop,
+		"hostname":         hostname,
+		"ip_addresses":     getIPAddresses(),
+		"client_address":   clientAddress,
+		"path":             r.URL.Path,
+		"received_headers": chainReceivedHeaders(r.Header),
+		"timeout":          timeout.String(),
+	}
+
+	status := http.StatusOK
+	if len(hops) > 0 {
+		response["next_hop"] = hops[0]
+
+		downstreamStart := time.Now()
+		downstream, downstreamStatus, err := forwardChain(r.Context(), hops[0], hops[1:], hop+1, timeout, chainForwardHeaders(r.Header))
+		response["downstream_latency"] = time.Since(downstreamStart).String()
+		if downstreamStatus != 0 {
+			response["downstream_status"] = downstreamStatus
+		}
+		if downstream != nil {
+			response["downstream"] = downstream
+		}
+		if err != nil {
+			response["downstream_error"] = err.Error()
+			status = http.StatusBadGateway
+		} else if downstreamStatus != http.StatusOK {
+			status = http.StatusBadGateway
+		}
+	}
+
+	response["latency"] = time.Since(startTime).String()
+	response["timestamp"] = time.Now().Format(time.RFC3339Nano)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// chainSkippedHeaders are not forwarded to the next hop: hop-by-hop headers,
+// headers the client sets itself, headers proxies add, and trace headers,
+// which are replaced with this hop's span
+var chainSkippedHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Host", "Content-Length", "User-Agent", "Accept-Encoding",
+	"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Real-Ip",
+	"Traceparent", "Tracestate", "B3", "X-B3-Traceid", "X-B3-Spanid", "X-B3-Parentspanid", "X-B3-Sampled", "X-B3-Flags", "X-Request-Id",
+}
+
+// chainReceivedHeaders returns the headers of a hop for its response, with
+// credentials masked. The headers are forwarded to every later hop, so
+// they would otherwise be copied into each nested response.
+func chainReceivedHeaders(h http.Header) http.Header {
+	masked := maskAuthorizationHeaders(h)
+	for i, header := range masked["Cookie"] {
+		pairs := strings.Split(header, ";")
+		for j, pair := range pairs {
+			name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			pairs[j] = name + "=" + maskToken(value)
+		}
+		masked["Cookie"][i] = strings.Join(pairs, "; ")
+	}
+	return masked
+}
+
+// chainForwardHeaders returns the headers of the client's request that are
+// passed on to every hop, such as Authorization, baggage and custom headers
+func chainForwardHeaders(h http.Header) http.Header {
+	forward := h.Clone()
+	for _, name := range chainSkippedHeaders {
+		forward.Del(name)
+	}
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			forward.Del(strings.TrimSpace(name))
+		}
+	}
+	return forward
+}
+
+// chainURL returns the /chain URL of next carrying the remaining hops and
+// the per-hop timeout. next is either host:port or a base URL such as
+// https://svc:8443.
+func chainURL(next string, rest []string, hop int, timeout time.Duration) (*url.URL, error) {
+	if !strings.Contains(next, "://") {
+		next = "http://" + next
+	}
+	target, err := url.Parse(next)
+	if err != nil {
+		return nil, err
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid hop: %s", next)
+	}
+
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/chain"
+	query := url.Values{"hop": {strconv.Itoa(hop)}, "timeout": {timeout.String()}}
+	if len(rest) > 0 {
+		query.Set("hops", strings.Join(rest, ","))
+	}
+	target.RawQuery = query.Encode()
+	return target, nil
+}
+
+// forwardChain sends the chain request to next with the forwarded headers
+// and returns the decoded downstream response and its status code
+func forwardChain(ctx context.Context, next string, rest []string, hop int, timeout time.Duration, headers http.Header) (map[string]interface{}, int, error) {
+	target, err := chainURL(next, rest, hop, timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	dialer, err := probeDialer(target.Hostname(), port, timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Each hop needs time to reach the ones after it
+	ctx, cancel := context.WithTimeout(ctx, timeout*time.Duration(len(rest)+1))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header = headers
+	req.Header.Set("User-Agent", "debug-httpd-chain")
+	injectTraceHeaders(ctx, req.Header)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       dialer.DialContext,
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	var downstream map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&downstream); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("could not parse downstream response: %v", err)
+	}
+	return downstream, resp.StatusCode, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// printUsage prints the list of subcommands
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, `Usage: debug-httpd <command> [flags] [args]
+
+Commands:
+  serve [flags] [port]   Start the HTTP server (default)
+  check [flags] [url]    Request url and exit 0 on a 2xx response, 1 otherwise
+                         (default url: http://localhost:$PORT/ping)
+  get [flags] url        Request url and print the response body
+  help                   Show this message
+
+Run 'debug-httpd <command> -h' for the flags of each command.
+`)
+}
+
+// headerFlags collects repeated -H flags
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header must be in 'Name: value' form: %q", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// defaultCheckURL returns the /ping URL of the server on this host
+func defaultCheckURL() string {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "9876"
+	}
+	return fmt.Sprintf("http://localhost:%s/ping", port)
+}
+
+// runCheck requests a URL and returns the exit code for a health check:
+// 0 if the response has the expected status, 1 otherwise
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	timeout := fs.Duration("timeout", 5*time.Second, "Request timeout")
+	status := fs.Int("status", 0, "Expected status code (default: any 2xx)")
+	quiet := fs.Bool("q", false, "Don't print the result")
+	insecure := fs.Bool("k", false, "Skip TLS certificate verification")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	url := defaultCheckURL()
+	if fs.NArg() > 0 {
+		url = fs.Arg(0)
+	}
+
+	client := newCLIClient(*timeout, *insecure)
+	resp, err := client.Get(url)
+	if err != nil {
+		if !*quiet {
+			fmt.Fprintf(stderr, "check failed: %v\n", err)
+		}
+		return 1
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	healthy := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if *status != 0 {
+		healthy = resp.StatusCode == *status
+	}
+
+	if !healthy {
+		if !*quiet {
+			fmt.Fprintf(stderr, "check failed: %s returned %s\n", url, resp.Status)
+		}
+		return 1
+	}
+	if !*quiet {
+		fmt.Fprintf(stdout, "%s returned %s\n", url, resp.Status)
+	}
+	return 0
+}
+
+// runGet requests a URL and prints the response, returning the exit code:
+// 0 on success, 1 if the request failed (or returned a non-2xx status with
+// -fail)
+func runGet(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	timeout := fs.Duration("timeout", 30*time.Second, "Request timeout")
+	method := fs.String("X", http.MethodGet, "HTTP method")
+	data := fs.String("d", "", "Request body")
+	include := fs.Bool("i", false, "Print the status line and response headers")
+	insecure := fs.Bool("k", false, "Skip TLS certificate verification")
+	fail := fs.Bool("fail", false, "Exit 1 on a non-2xx response")
+	var headers headerFlags
+	fs.Var(&headers, "H", "Request header in 'Name: value' form (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: debug-httpd get [flags] url")
+		return 2
+	}
+
+	var body io.Reader
+	if *data != "" {
+		body = strings.NewReader(*data)
+	}
+	req, err := http.NewRequest(strings.ToUpper(*method), fs.Arg(0), body)
+	if err != nil {
+		fmt.Fprintf(stderr, "get failed: %v\n", err)
+		return 1
+	}
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	client := newCLIClient(*timeout, *insecure)
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(stderr, "get failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if *include {
+		fmt.Fprintf(stdout, "%s %s\n", resp.Proto, resp.Status)
+		names := make([]string, 0, len(resp.Header))
+		for name := range resp.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range resp.Header[name] {
+				fmt.Fprintf(stdout, "%s: %s\n", name, value)
+			}
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	if _, err := io.Copy(stdout, resp.Body); err != nil {
+		fmt.Fprintf(stderr, "get failed: %v\n", err)
+		return 1
+	}
+
+	if *fail && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return 1
+	}
+	return 0
+}
+
+// newCLIClient returns the HTTP client used by the check and get commands
+func newCLIClient(timeout time.Duration, insecure bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
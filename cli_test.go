@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunCheck(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		args     []string
+		exitCode int
+	}{
+		{"healthy", []string{server.URL + "/ping"}, 0},
+		{"error status", []string{server.URL + "/status/503"}, 1},
+		{"expected status", []string{"-status", "503", server.URL + "/status/503"}, 0},
+		{"unexpected status", []string{"-status", "204", server.URL + "/ping"}, 1},
+		{"connection refused", []string{"http://127.0.0.1:1/ping"}, 1},
+		{"invalid flag", []string{"-no-such-flag"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if got := runCheck(tt.args, &stdout, &stderr); got != tt.exitCode {
+				t.Errorf("runCheck(%v) = %d, want %d (stderr: %s)", tt.args, got, tt.exitCode, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunCheck_DefaultURL(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	t.Setenv("PORT", server.URL[strings.LastIndex(server.URL, ":")+1:])
+
+	var stdout, stderr bytes.Buffer
+	if got := runCheck(nil, &stdout, &stderr); got != 0 {
+		t.Errorf("runCheck() = %d, want 0 (stderr: %s)", got, stderr.String())
+	}
+}
+
+func TestRunGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Test", r.Header.Get("X-Test"))
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := runGet([]string{"-i", "-X", "post", "-H", "X-Test: hello", "-d", "payload", server.URL}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("runGet returned %d (stderr: %s)", code, stderr.String())
+	}
+
+	output := stdout.String()
+	for _, want := range []string{"HTTP/1.1 200 OK\n", "X-Method: POST\n", "X-Test: hello\n", "\n\npayload"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output missing %q:\n%s", want, output)
+		}
+	}
+
+	stdout.Reset()
+	if code := runGet([]string{server.URL + "/missing"}, &stdout, &stderr); code != 0 {
+		t.Errorf("runGet without -fail returned %d for a 404", code)
+	}
+	if code := runGet([]string{"-fail", server.URL + "/missing"}, &stdout, &stderr); code != 1 {
+		t.Errorf("runGet with -fail returned %d for a 404, want 1", code)
+	}
+	if code := runGet(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("runGet without url returned %d, want 2", code)
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// compressionEncodings are the content codings -compress can negotiate, in
+// the order preferred when the client accepts several equally. br and zstd
+// are only written as uncompressed blocks (see brotliWriter), so they are
+// served by /brotli and /zstd but not negotiated.
+var compressionEncodings = []string{"gzip", "deflate"}
+
+// compressionConfig lists the encodings responses are compressed with,
+// in order of preference. It is configured by the -compress flag and empty
+// when compression is disabled.
+var compressionConfig []string
+
+// compressor is a streaming encoder for a content coding
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newCompressor returns an encoder for the content coding writing to w
+func newCompressor(encoding string, w io.Writer) compressor {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w)
+	case "deflate":
+		// HTTP deflate is the zlib format (RFC 9110 section 8.4.1.2)
+		return zlib.NewWriter(w)
+	case "br":
+		return &brotliWriter{w: w}
+	case "zstd":
+		return &zstdWriter{w: w}
+	}
+	return nil
+}
+
+// ParseCompressionConfig parses a comma separated list of encodings, or
+// "all" for every supported one
+func ParseCompressionConfig(s string) ([]string, error) {
+	if s == "" || s == "off" {
+		return nil, nil
+	}
+	if s == "all" {
+		return compressionEncodings, nil
+	}
+
+	var encodings []string
+	for _, encoding := range strings.Split(s, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if !slices.Contains(compressionEncodings, encoding) {
+			return nil, fmt.Errorf("unsupported encoding %q (use %s)", encoding, strings.Join(compressionEncodings, ", "))
+		}
+		encodings = append(encodings, encoding)
+	}
+	return encodings, nil
+}
+
+// negotiateEncoding picks the encoding to use from an Accept-Encoding
+// header: the one with the highest q-value, preferring the earlier one in
+// supported on ties. It returns "" when identity should be used.
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		q, ok := weights[encoding]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// Trailers reporting the body size before and after compression
+const (
+	uncompressedBytesTrailer = "X-Uncompressed-Bytes"
+	compressedBytesTrailer   = "X-Compressed-Bytes"
+)
+
+// byteCounter counts the bytes written to w
+type byteCounter struct {
+	w io.Writer
+	n int
+}
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
+
+// compressWriter compresses the response unless the handler already set a
+// Content-Encoding or the response has no body. The sizes of the body are
+// sent as trailers.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding     string
+	method       string
+	compressor   compressor
+	uncompressed int
+	compressed   *byteCounter
+	wroteHeader  bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader || code < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.method != http.MethodHead &&
+		code != http.StatusNoContent && code != http.StatusNotModified && code != http.StatusPartialContent {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Add("Trailer", uncompressedBytesTrailer)
+		h.Add("Trailer", compressedBytesTrailer)
+		cw.compressed = &byteCounter{w: cw.ResponseWriter}
+		cw.compressor = newCompressor(cw.encoding, cw.compressed)
+	}
+	if code == http.StatusOK || code == http.StatusNotModified || cw.compressor != nil {
+		weakenETag(h)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// weakenETag makes a strong ETag weak, as the encoded representation is
+// not byte-for-byte identical to the identity one
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.compressor != nil {
+		n, err := cw.compressor.Write(b)
+		cw.uncompressed += n
+		return n, err
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *compressWriter) Flush() {
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the compressed stream and sets the size trailers
+func (cw *compressWriter) close() {
+	if cw.compressor != nil {
+		cw.compressor.Close()
+		cw.Header().Set(uncompressedBytesTrailer, strconv.Itoa(cw.uncompressed))
+		cw.Header().Set(compressedBytesTrailer, strconv.Itoa(cw.compressed.n))
+	}
+}
+
+type compressionKey struct{}
+
+// withCompression compresses responses with the encoding negotiated from
+// Accept-Encoding when compressionConfig is set
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(compressionConfig) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), compressionConfig)
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, method: r.Method}
+		defer cw.close()
+		next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), compressionKey{}, encoding)))
+	})
+}
+
+// compressionFromRequest returns the encoding withCompression negotiated
+// for r, or "" if the response is not compressed
+func compressionFromRequest(r *http.Request) string {
+	encoding, _ := r.Context().Value(compressionKey{}).(string)
+	return encoding
+}
+
+// compressionInfo describes how the response to r is compressed
+func compressionInfo(r *http.Request, encoding string) map[string]interface{} {
+	info := map[string]interface{}{
+		"accept_encoding":     strings.Join(r.Header.Values("Accept-Encoding"), ", "),
+		"negotiated_encoding": "identity",
+	}
+	if encoding != "" {
+		info["negotiated_encoding"] = encoding
+	}
+	return info
+}
+
+// compressedSize returns the size of b encoded with encoding, or len(b)
+// for identity
+func compressedSize(encoding string, b []byte) int {
+	if encoding == "" {
+		return len(b)
+	}
+	var buf bytes.Buffer
+	c := newCompressor(encoding, &buf)
+	c.Write(b)
+	c.Close()
+	return buf.Len()
+}
+
+// encodeWithCompressionSizes marshals response, which contains info,
+// filling in info's uncompressed_bytes and compressed_bytes with the sizes
+// of the returned body itself. The sizes are part of the body, so they are
+// refined until they describe it. A change in the digits can change the
+// compressed size back and forth, so when refining doesn't settle, trailing
+// whitespace is added to the JSON to compress it differently.
+func encodeWithCompressionSizes(response, info map[string]interface{}, encoding string) []byte {
+	var body []byte
+	for padding := 0; padding < 64; padding++ {
+		for i := 0; i < 4; i++ {
+			body, _ = json.Marshal(response)
+			body = append(body, strings.Repeat(" ", padding)+"\n"...)
+			compressed := compressedSize(encoding, body)
+			if info["uncompressed_bytes"] == len(body) && info["compressed_bytes"] == compressed {
+				return body
+			}
+			info["uncompressed_bytes"] = len(body)
+			info["compressed_bytes"] = compressed
+		}
+	}
+	return body
+}
+
+// compressedHandler returns a handler that always compresses its response
+// with encoding, regardless of Accept-Encoding
+func compressedHandler(encoding string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logAccess(r)
+
+		info := compressionInfo(r, encoding)
+		response := map[string]interface{}{
+			"method":      r.Method,
+			"headers":     maskAuthorizationHeaders(r.Header),
+			"compression": info,
+		}
+		body := encodeWithCompressionSizes(response, info, encoding)
+
+		var compressed bytes.Buffer
+		c := newCompressor(encoding, &compressed)
+		c.Write(body)
+		c.Close()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set(uncompressedBytesTrailer, strconv.Itoa(len(body)))
+		w.Header().Set(compressedBytesTrailer, strconv.Itoa(compressed.Len()))
+		w.WriteHeader(http.StatusOK)
+		w.Write(compressed.Bytes())
+	}
+}
+
+// brotliWriter writes a valid Brotli stream (RFC 7932) made of uncompressed
+// meta-blocks. The standard library has no Brotli encoder, so the output
+// is slightly larger than the input, but any decoder accepts it.
+type brotliWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// brotliMaxBlock is the largest meta-block with a 4 nibble length
+const brotliMaxBlock = 1 << 16
+
+func (bw *brotliWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), brotliMaxBlock)
+
+		// ISLAST=0, MNIBBLES=4, MLEN-1 (16 bits), ISUNCOMPRESSED=1, padded to a
+		// byte. The stream header (WBITS=16, a single 0 bit) precedes the
+		// first meta-block.
+		bits := uint32(n-1)<<3 | 1<<19
+		if !bw.started {
+			bits <<= 1
+			bw.started = true
+		}
+		var header [4]byte
+		binary.LittleEndian.PutUint32(header[:], bits)
+		if _, err := bw.w.Write(header[:3]); err != nil {
+			return written, err
+		}
+		if _, err := bw.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Flush does nothing as meta-blocks are written as soon as they are complete
+func (bw *brotliWriter) Flush() error {
+	return nil
+}
+
+// Close writes the last, empty meta-block (ISLAST=1, ISLASTEMPTY=1)
+func (bw *brotliWriter) Close() error {
+	last := byte(0x03)
+	if !bw.started {
+		last = 0x06
+	}
+	_, err := bw.w.Write([]byte{last})
+	return err
+}
+
+// zstdWriter writes a valid Zstandard frame (RFC 8878) made of raw blocks.
+// The standard library has no Zstandard encoder, so the output is slightly
+// larger than the input, but any decoder accepts it.
+type zstdWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// zstdMaxBlock is the largest block allowed by the 128KiB window
+const zstdMaxBlock = 128 << 10
+
+// writeBlock writes a raw block with its 3 byte header
+func (zw *zstdWriter) writeBlock(p []byte, last bool) error {
+	if !zw.started {
+		// Magic number, a frame header descriptor without content size or
+		// checksum and a 128KiB window descriptor
+		if _, err := zw.w.Write([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38}); err != nil {
+			return err
+		}
+		zw.started = true
+	}
+
+	header := uint32(len(p)) << 3
+	if last {
+		header |= 1
+	}
+	if _, err := zw.w.Write([]byte{byte(header), byte(header >> 8), byte(header >> 16)}); err != nil {
+		return err
+	}
+	_, err := zw.w.Write(p)
+	return err
+}
+
+func (zw *zstdWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), zstdMaxBlock)
+		if err := zw.writeBlock(p[:n], false); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Flush does nothing as blocks are written as soon as they are complete
+func (zw *zstdWriter) Flush() error {
+	return nil
+}
+
+// Close ends the frame with an empty last block
+func (zw *zstdWriter) Close() error {
+	return zw.writeBlock(nil, true)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{"gzip", "deflate"}
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, br", "deflate"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"GZIP", "gzip"},
+		{"gzip;q=0, identity", ""},
+		{"*", "gzip"},
+		{"*;q=0.1, gzip;q=0", "deflate"},
+		{"compress", ""},
+		{"br, zstd", ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding, supported); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestParseCompressionConfig(t *testing.T) {
+	if encodings, err := ParseCompressionConfig("deflate, GZIP"); err != nil || strings.Join(encodings, ",") != "deflate,gzip" {
+		t.Errorf("unexpected result: %v %v", encodings, err)
+	}
+	if encodings, err := ParseCompressionConfig("all"); err != nil || len(encodings) != 2 {
+		t.Errorf("unexpected result: %v %v", encodings, err)
+	}
+	if encodings, err := ParseCompressionConfig(""); err != nil || encodings != nil {
+		t.Errorf("unexpected result: %v %v", encodings, err)
+	}
+	// br and zstd are only served by /brotli and /zstd
+	for _, s := range []string{"gzip,lzma", "br", "zstd"} {
+		if _, err := ParseCompressionConfig(s); err == nil {
+			t.Errorf("%s: expected an error for an unsupported encoding", s)
+		}
+	}
+}
+
+// decodeBrotliUncompressed decodes a Brotli stream made of uncompressed
+// meta-blocks, as written by brotliWriter
+func decodeBrotliUncompressed(t *testing.T, b []byte) []byte {
+	if b[0]&1 != 0 {
+		t.Fatalf("unexpected WBITS in %x", b[:1])
+	}
+	var out []byte
+	shift := 1
+	for {
+		if len(b) == 0 {
+			t.Fatal("stream ended without a last meta-block")
+		}
+		if b[0]>>shift&1 == 1 {
+			if b[0]>>(shift+1)&1 != 1 {
+				t.Fatal("last meta-block is not empty")
+			}
+			return out
+		}
+		bits := (uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16) >> shift
+		if bits>>1&3 != 0 || bits>>19&1 != 1 {
+			t.Fatalf("unexpected meta-block header %x", b[:3])
+		}
+		n := int(bits>>3&0xffff) + 1
+		out = append(out, b[3:3+n]...)
+		b = b[3+n:]
+		shift = 0
+	}
+}
+
+// decodeZstdRaw decodes a Zstandard frame made of raw blocks, as written by
+// zstdWriter
+func decodeZstdRaw(t *testing.T, b []byte) []byte {
+	if binary.LittleEndian.Uint32(b) != 0xfd2fb528 {
+		t.Fatalf("unexpected magic number %x", b[:4])
+	}
+	b = b[6:]
+	var out []byte
+	for {
+		header := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+		if header>>1&3 != 0 {
+			t.Fatalf("unexpected block type %d", header>>1&3)
+		}
+		n := int(header >> 3)
+		out = append(out, b[3:3+n]...)
+		b = b[3+n:]
+		if header&1 == 1 {
+			if len(b) != 0 {
+				t.Fatalf("%d bytes after the last block", len(b))
+			}
+			return out
+		}
+	}
+}
+
+// decompress decodes a response body with the content coding
+func decompress(t *testing.T, encoding string, body []byte) []byte {
+	switch encoding {
+	case "br":
+		return decodeBrotliUncompressed(t, body)
+	case "zstd":
+		return decodeZstdRaw(t, body)
+	}
+
+	var r io.Reader
+	var err error
+	if encoding == "gzip" {
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	} else {
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestCompressors(t *testing.T) {
+	large := bytes.Repeat([]byte("debug-httpd "), 20000)
+
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		for _, data := range [][]byte{nil, []byte("x"), large} {
+			var buf bytes.Buffer
+			c := newCompressor(encoding, &buf)
+			c.Write(data)
+			c.Flush()
+			c.Close()
+
+			if decoded := decompress(t, encoding, buf.Bytes()); !bytes.Equal(decoded, data) {
+				t.Errorf("%s: round trip of %d bytes returned %d bytes", encoding, len(data), len(decoded))
+			}
+		}
+	}
+}
+
+// withCompressionConfig sets compressionConfig for the duration of a test
+func withCompressionConfig(t *testing.T, encodings ...string) {
+	original := compressionConfig
+	compressionConfig = encodings
+	t.Cleanup(func() { compressionConfig = original })
+}
+
+func TestWithCompression(t *testing.T) {
+	withCompressionConfig(t, "gzip", "deflate")
+	handler := newHandler()
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding got %q want %q", got, "gzip")
+	}
+	if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary got %q want %q", got, "Accept-Encoding")
+	}
+	body := decompress(t, "gzip", rr.Body.Bytes())
+
+	var response struct {
+		Compression struct {
+			Encoding          string `json:"negotiated_encoding"`
+			UncompressedBytes int    `json:"uncompressed_bytes"`
+			CompressedBytes   int    `json:"compressed_bytes"`
+		} `json:"compression"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	c := response.Compression
+	if c.Encoding != "gzip" || c.UncompressedBytes != len(body) || c.CompressedBytes != rr.Body.Len() {
+		t.Errorf("unexpected compression report %+v, body %d bytes, compressed %d bytes", c, len(body), rr.Body.Len())
+	}
+
+	// The sizes are sent as trailers once the body is complete
+	trailer := rr.Result().Trailer
+	if got := trailer.Get("X-Uncompressed-Bytes"); got != strconv.Itoa(len(body)) {
+		t.Errorf("X-Uncompressed-Bytes got %q want %d", got, len(body))
+	}
+	if got := trailer.Get("X-Compressed-Bytes"); got != strconv.Itoa(rr.Body.Len()) {
+		t.Errorf("X-Compressed-Bytes got %q want %d", got, rr.Body.Len())
+	}
+
+	// The encoded representation has a weak ETag, also in 304 responses
+	req, _ = http.NewRequest("GET", "/cache/60", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	etag := rr.Header().Get("ETag")
+	if rr.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("unexpected ETag %q for Content-Encoding %q", etag, rr.Header().Get("Content-Encoding"))
+	}
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != etag {
+		t.Errorf("unexpected revalidation: %d, ETag %q want %q", rr.Code, rr.Header().Get("ETag"), etag)
+	}
+
+	// Not accepted, so sent as is
+	req, _ = http.NewRequest("GET", "/ping", nil)
+	req.Header.Set("Accept-Encoding", "zstd")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "pong" {
+		t.Errorf("unexpected response: %v %q", rr.Header(), rr.Body.String())
+	}
+
+	// No body
+	req, _ = http.NewRequest("GET", "/status/204", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("204 response has Content-Encoding %q", got)
+	}
+}
+
+func TestCompressedHandler(t *testing.T) {
+	// Compressed even without Accept-Encoding, and not compressed twice
+	withCompressionConfig(t, "gzip")
+	handler := newHandler()
+
+	for path, encoding := range map[string]string{"/gzip": "gzip", "/deflate": "deflate", "/brotli": "br", "/zstd": "zstd"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Content-Encoding"); got != encoding {
+			t.Errorf("%s: Content-Encoding got %q want %q", path, got, encoding)
+			continue
+		}
+		body := decompress(t, encoding, rr.Body.Bytes())
+		if !bytes.Contains(body, []byte(`"negotiated_encoding":"`+encoding+`"`)) ||
+			!bytes.Contains(body, []byte(`"compressed_bytes":`+strconv.Itoa(rr.Body.Len()))) {
+			t.Errorf("%s: unexpected body %s", path, body)
+		}
+		if rr.Header().Get("X-Uncompressed-Bytes") != strconv.Itoa(len(body)) || rr.Header().Get("X-Compressed-Bytes") != strconv.Itoa(rr.Body.Len()) {
+			t.Errorf("%s: unexpected sizes %q %q for %d and %d bytes", path,
+				rr.Header().Get("X-Uncompressed-Bytes"), rr.Header().Get("X-Compressed-Bytes"), len(body), rr.Body.Len())
+		}
+	}
+}
+
+func TestEncodeWithCompressionSizes(t *testing.T) {
+	// The sizes must describe the body they are part of for any content
+	for i := 0; i < 500; i++ {
+		for _, encoding := range []string{"", "gzip", "deflate", "br"} {
+			info := map[string]interface{}{"negotiated_encoding": encoding}
+			response := map[string]interface{}{"value": strings.Repeat("ab", i) + strconv.Itoa(i*7919), "compression": info}
+			body := encodeWithCompressionSizes(response, info, encoding)
+
+			var decoded struct {
+				Compression struct {
+					UncompressedBytes int `json:"uncompressed_bytes"`
+					CompressedBytes   int `json:"compressed_bytes"`
+				} `json:"compression"`
+			}
+			if err := json.Unmarshal(body, &decoded); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+			c := decoded.Compression
+			if c.UncompressedBytes != len(body) || c.CompressedBytes != compressedSize(encoding, body) {
+				t.Fatalf("%q %d: reported %+v for %d bytes compressed to %d", encoding, i, c, len(body), compressedSize(encoding, body))
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestCookie is a cookie sent by the client
+type requestCookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Quoted bool   `json:"quoted,omitempty"`
+}
+
+// parseRequestCookies returns the cookies of the request in the order they
+// were sent, keeping duplicate names, and the pairs that are not valid
+// cookies
+func parseRequestCookies(r *http.Request) ([]requestCookie, []string) {
+	cookies := []requestCookie{}
+	var invalid []string
+	for _, header := range r.Header["Cookie"] {
+		for _, pair := range strings.Split(header, ";") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(pair, "=")
+			cookie := requestCookie{Name: name, Value: value}
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				cookie.Value, cookie.Quoted = value[1:len(value)-1], true
+			}
+			if !ok || (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).Valid() != nil {
+				invalid = append(invalid, pair)
+				continue
+			}
+			cookies = append(cookies, cookie)
+		}
+	}
+	return cookies, invalid
+}
+
+// cookieAttributes are the attributes given by the attrs parameter, in
+// Set-Cookie syntax (e.g. "Path=/; Secure; SameSite=None")
+type cookieAttributes struct {
+	template    http.Cookie
+	partitioned bool
+}
+
+// parseCookieAttributes parses the attrs parameter. Path defaults to / so
+// cookies set here are sent to every endpoint.
+func parseCookieAttributes(s string) (cookieAttributes, error) {
+	attrs := cookieAttributes{template: http.Cookie{Path: "/"}}
+	for _, attr := range strings.Split(s, ";") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(attr, "=")
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "path":
+			attrs.template.Path = value
+		case "domain":
+			attrs.template.Domain = value
+		case "max-age":
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return attrs, fmt.Errorf("invalid Max-Age %q", value)
+			}
+			// http.Cookie uses a negative MaxAge for Max-Age=0
+			attrs.template.MaxAge = seconds
+			if seconds <= 0 {
+				attrs.template.MaxAge = -1
+			}
+		case "expires":
+			expires, err := http.ParseTime(value)
+			if err != nil {
+				return attrs, fmt.Errorf("invalid Expires %q (use an HTTP date such as %q)", value, time.Now().UTC().Format(http.TimeFormat))
+			}
+			attrs.template.Expires = expires
+		case "secure":
+			attrs.template.Secure = true
+		case "httponly":
+			attrs.template.HttpOnly = true
+		case "partitioned":
+			attrs.partitioned = true
+		case "samesite":
+			switch strings.ToLower(value) {
+			case "strict":
+				attrs.template.SameSite = http.SameSiteStrictMode
+			case "lax":
+				attrs.template.SameSite = http.SameSiteLaxMode
+			case "none":
+				attrs.template.SameSite = http.SameSiteNoneMode
+			default:
+				return attrs, fmt.Errorf("SameSite must be Strict, Lax or None")
+			}
+		default:
+			return attrs, fmt.Errorf("unknown cookie attribute %q", name)
+		}
+	}
+	return attrs, nil
+}
+
+// warnings returns the reasons browsers would reject cookies with these
+// attributes
+func (a cookieAttributes) warnings() []string {
+	var warnings []string
+	if a.template.SameSite == http.SameSiteNoneMode && !a.template.Secure {
+		warnings = append(warnings, "browsers reject SameSite=None without Secure")
+	}
+	if a.partitioned && !a.template.Secure {
+		warnings = append(warnings, "browsers reject Partitioned without Secure")
+	}
+	return warnings
+}
+
+// setCookie adds a Set-Cookie header for name and value with the attributes,
+// returning the header value
+func (a cookieAttributes) setCookie(w http.ResponseWriter, name, value string) (string, error) {
+	cookie := a.template
+	cookie.Name, cookie.Value = name, value
+	if err := cookie.Valid(); err != nil {
+		return "", err
+	}
+	header := cookie.String()
+	// http.Cookie has no Partitioned field before Go 1.23
+	if a.partitioned {
+		header += "; Partitioned"
+	}
+	w.Header().Add("Set-Cookie", header)
+	return header, nil
+}
+
+// cookieQuery parses the query of r, splitting pairs on & only.
+// URL.Query drops pairs containing ";", which attrs uses unescaped
+// (e.g. attrs=Path=/;Secure).
+func cookieQuery(r *http.Request) url.Values {
+	query := url.Values{}
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		query[name] = append(query[name], value)
+	}
+	return query
+}
+
+// cookieParamPrefix marks the query parameters that name cookies, so
+// parameters other middleware consumes (throttle) or cache-busting ones
+// aren't set as cookies
+const cookieParamPrefix = "c."
+
+// cookieParams returns the query parameters named with cookieParamPrefix,
+// keyed by cookie name
+func cookieParams(query url.Values) url.Values {
+	params := url.Values{}
+	for name, values := range query {
+		if cookieName, ok := strings.CutPrefix(name, cookieParamPrefix); ok {
+			params[cookieName] = append(params[cookieName], values...)
+		}
+	}
+	return params
+}
+
+// cookieNames returns the sorted cookie names in params
+func cookieNames(params url.Values) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeCookieError sends 400 with the error
+func writeCookieError(w http.ResponseWriter, err error, example string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   err.Error(),
+		"example": example,
+	})
+}
+
+// cookiesHandler handles /cookies requests by listing the cookies sent
+func cookiesHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	cookies, invalid := parseRequestCookies(r)
+	response := map[string]interface{}{
+		"cookies": cookies,
+		"count":   len(cookies),
+		"raw":     r.Header["Cookie"],
+	}
+	if len(invalid) > 0 {
+		response["invalid"] = invalid
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// setCookiesHandler handles /cookies/set?c.name=value&attrs=... requests by
+// setting every c. query parameter as a cookie
+func setCookiesHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	const example = "/cookies/set?c.session=abc123&attrs=Path=/;Secure;HttpOnly;SameSite=None;Max-Age=3600"
+	query := cookieQuery(r)
+	attrs, err := parseCookieAttributes(query.Get("attrs"))
+	if err != nil {
+		writeCookieError(w, err, example)
+		return
+	}
+	params := cookieParams(query)
+	names := cookieNames(params)
+	if len(names) == 0 {
+		writeCookieError(w, fmt.Errorf("no cookies given, name them with the %q prefix", cookieParamPrefix), example)
+		return
+	}
+
+	var headers []string
+	for _, name := range names {
+		for _, value := range params[name] {
+			header, err := attrs.setCookie(w, name, value)
+			if err != nil {
+				w.Header().Del("Set-Cookie")
+				writeCookieError(w, err, example)
+				return
+			}
+			headers = append(headers, header)
+		}
+	}
+
+	response := map[string]interface{}{"set_cookie": headers}
+	if warnings := attrs.warnings(); len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// deleteCookiesHandler handles /cookies/delete?c.name&attrs=... requests by
+// expiring the named cookies, or every cookie sent if none are named. Path
+// and Domain in attrs must match the ones the cookie was set with.
+func deleteCookiesHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	const example = "/cookies/delete?c.session&attrs=Path=/app"
+	query := cookieQuery(r)
+	attrs, err := parseCookieAttributes(query.Get("attrs"))
+	if err != nil {
+		writeCookieError(w, err, example)
+		return
+	}
+	attrs.template.MaxAge = -1
+	attrs.template.Expires = time.Unix(0, 0)
+
+	names := cookieNames(cookieParams(query))
+	if len(names) == 0 {
+		cookies, _ := parseRequestCookies(r)
+		seen := map[string]bool{}
+		for _, cookie := range cookies {
+			if !seen[cookie.Name] {
+				seen[cookie.Name] = true
+				names = append(names, cookie.Name)
+			}
+		}
+	}
+
+	headers := []string{}
+	for _, name := range names {
+		header, err := attrs.setCookie(w, name, "")
+		if err != nil {
+			w.Header().Del("Set-Cookie")
+			writeCookieError(w, err, example)
+			return
+		}
+		headers = append(headers, header)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted":    names,
+		"set_cookie": headers,
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCookiesHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/cookies", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Cookie", `SERVERID=app-1; session="abc"; bad name=x`)
+	req.Header.Add("Cookie", "SERVERID=app-2")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cookiesHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response struct {
+		Cookies []requestCookie `json:"cookies"`
+		Invalid []string        `json:"invalid"`
+		Raw     []string        `json:"raw"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	want := []requestCookie{
+		{Name: "SERVERID", Value: "app-1"},
+		{Name: "session", Value: "abc", Quoted: true},
+		{Name: "SERVERID", Value: "app-2"},
+	}
+	if len(response.Cookies) != len(want) {
+		t.Fatalf("unexpected cookies: %v", response.Cookies)
+	}
+	for i := range want {
+		if response.Cookies[i] != want[i] {
+			t.Errorf("cookie %d: got %v want %v", i, response.Cookies[i], want[i])
+		}
+	}
+	if len(response.Invalid) != 1 || response.Invalid[0] != "bad name=x" {
+		t.Errorf("unexpected invalid cookies: %v", response.Invalid)
+	}
+	if len(response.Raw) != 2 {
+		t.Errorf("unexpected raw headers: %v", response.Raw)
+	}
+}
+
+func TestSetCookiesHandler(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected int
+		want     []string
+		warnings int
+	}{
+		{"c.a=1&c.b=2", http.StatusOK, []string{"a=1; Path=/", "b=2; Path=/"}, 0},
+		{"c.s=x&attrs=" + url.QueryEscape("Path=/app; Domain=example.com; Max-Age=60; Secure; HttpOnly; SameSite=None; Partitioned"),
+			http.StatusOK, []string{"s=x; Path=/app; Domain=example.com; Max-Age=60; HttpOnly; Secure; SameSite=None; Partitioned"}, 0},
+		// The example in the README, with unescaped ";"
+		{"c.session=abc123&attrs=Path=/;Secure;HttpOnly;SameSite=None;Max-Age=3600",
+			http.StatusOK, []string{"session=abc123; Path=/; Max-Age=3600; HttpOnly; Secure; SameSite=None"}, 0},
+		{"c.s=x&attrs=SameSite=None", http.StatusOK, []string{"s=x; Path=/; SameSite=None"}, 1},
+		{"c.s=x&attrs=Max-Age=0", http.StatusOK, []string{"s=x; Path=/; Max-Age=0"}, 0},
+		{"c.s=x&attrs=SameSite=Loose", http.StatusBadRequest, nil, 0},
+		{"c.s=x&attrs=Color=red", http.StatusBadRequest, nil, 0},
+		{"attrs=Secure", http.StatusBadRequest, nil, 0},
+		{"c.s=a%3Bb", http.StatusBadRequest, nil, 0},
+		// Parameters without the c. prefix aren't cookies
+		{"c.s=x&throttle=10k&format=json&_=1700000000", http.StatusOK, []string{"s=x; Path=/"}, 0},
+		{"session=abc123", http.StatusBadRequest, nil, 0},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/cookies/set?"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(setCookiesHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tt.expected {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.query, status, tt.expected)
+			continue
+		}
+		got := rr.Header()["Set-Cookie"]
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: unexpected Set-Cookie: %q", tt.query, got)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: Set-Cookie got %q want %q", tt.query, got[i], tt.want[i])
+			}
+		}
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if warnings, _ := response["warnings"].([]interface{}); len(warnings) != tt.warnings {
+			t.Errorf("%s: unexpected warnings: %v", tt.query, warnings)
+		}
+	}
+}
+
+func TestDeleteCookiesHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/cookies/delete?attrs=Path=/app", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Cookie", "a=1; b=2; a=3")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(deleteCookiesHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	want := []string{
+		"a=; Path=/app; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0",
+		"b=; Path=/app; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0",
+	}
+	got := rr.Header()["Set-Cookie"]
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("unexpected Set-Cookie: %q", got)
+	}
+
+	// Only the c. parameters name the cookies to delete
+	req, err = http.NewRequest("GET", "/cookies/delete?c.b&throttle=10k", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Cookie", "a=1; b=2")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	got = rr.Header()["Set-Cookie"]
+	if len(got) != 1 || got[0] != "b=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0" {
+		t.Errorf("unexpected Set-Cookie: %q", got)
+	}
+}
+
+func TestDebugHandler_Cookies(t *testing.T) {
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Cookie", "AWSALB=xyz")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(debugHandler)
+	handler.ServeHTTP(rr, req)
+
+	var response struct {
+		Request struct {
+			Cookies []requestCookie `json:"cookies"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if len(response.Request.Cookies) != 1 || response.Request.Cookies[0].Name != "AWSALB" {
+		t.Errorf("unexpected cookies: %v", response.Request.Cookies)
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// corsConfig answers preflights and adds CORS headers to responses. It is
+// nil unless enabled with the -cors-origins or -cors-routes flags.
+var corsConfig *CORSConfig
+
+// defaultCORSMethods are the methods allowed when -cors-methods is not set
+const defaultCORSMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+
+// CORSPolicy is the CORS behavior of a route. Origins are "*", "null", an
+// exact origin or a wildcard subdomain such as https://*.example.com.
+// Methods and headers may contain "*" to allow any.
+type CORSPolicy struct {
+	AllowOrigins     []string `json:"allow_origins"`
+	AllowMethods     []string `json:"allow_methods"`
+	AllowHeaders     []string `json:"allow_headers"`
+	ExposeHeaders    []string `json:"expose_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"`
+}
+
+// CORSConfig holds the default policy and per-route policies by path
+// prefix. A nil policy disables CORS for the route.
+type CORSConfig struct {
+	policy *CORSPolicy
+	routes []corsRoute
+}
+
+type corsRoute struct {
+	prefix string
+	policy *CORSPolicy
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// validate checks the origins and max-age of the policy
+func (p *CORSPolicy) validate() error {
+	for _, origin := range p.AllowOrigins {
+		if origin == "*" || origin == "null" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("invalid origin %q (e.g. https://app.example.com or https://*.example.com)", origin)
+		}
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("max_age must not be negative")
+	}
+	return nil
+}
+
+// NewCORSConfig creates the configuration from the default policy and an
+// optional JSON file mapping path prefixes to policies. Fields missing from
+// a route policy are taken from the default one, and null disables CORS for
+// the route.
+func NewCORSConfig(policy CORSPolicy, routesPath string) (*CORSConfig, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	config := &CORSConfig{policy: &policy}
+	if routesPath == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(routesPath)
+	if err != nil {
+		return nil, err
+	}
+	var routes map[string]json.RawMessage
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", routesPath, err)
+	}
+	for prefix, raw := range routes {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid CORS route %q (expected a path prefix)", prefix)
+		}
+		route := corsRoute{prefix: prefix}
+		if string(raw) != "null" {
+			// Unmarshal over a copy that does not share the default's slices
+			p := policy
+			p.AllowOrigins = slices.Clone(p.AllowOrigins)
+			p.AllowMethods = slices.Clone(p.AllowMethods)
+			p.AllowHeaders = slices.Clone(p.AllowHeaders)
+			p.ExposeHeaders = slices.Clone(p.ExposeHeaders)
+			if err := json.Unmarshal(raw, &p); err != nil {
+				return nil, fmt.Errorf("invalid CORS route %q: %v", prefix, err)
+			}
+			if err := p.validate(); err != nil {
+				return nil, fmt.Errorf("invalid CORS route %q: %v", prefix, err)
+			}
+			route.policy = &p
+		}
+		config.routes = append(config.routes, route)
+	}
+
+	// Longest prefix first
+	sort.Slice(config.routes, func(i, j int) bool {
+		return len(config.routes[i].prefix) > len(config.routes[j].prefix)
+	})
+	return config, nil
+}
+
+// PolicyFor returns the policy for path, or nil if CORS is disabled for it
+func (c *CORSConfig) PolicyFor(path string) *CORSPolicy {
+	if c == nil {
+		return nil
+	}
+	for _, route := range c.routes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.policy
+		}
+	}
+	return c.policy
+}
+
+// String describes the configuration for the startup log
+func (c *CORSConfig) String() string {
+	parts := []string{"origins " + strings.Join(c.policy.AllowOrigins, ", ")}
+	if len(c.policy.AllowOrigins) == 0 {
+		parts[0] = "no origins"
+	}
+	if c.policy.AllowCredentials {
+		parts = append(parts, "with credentials")
+	}
+	for _, route := range c.routes {
+		if route.policy == nil {
+			parts = append(parts, route.prefix+"=off")
+		} else {
+			parts = append(parts, route.prefix+"="+strings.Join(route.policy.AllowOrigins, " "))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// matchOrigin returns the entry of AllowOrigins that origin matches
+func (p *CORSPolicy) matchOrigin(origin string) (string, bool) {
+	for _, allowed := range p.AllowOrigins {
+		if allowed == "*" && origin != "null" {
+			return allowed, true
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return allowed, true
+		}
+		scheme, suffix, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		host, ok := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+		if ok && len(host) > len(suffix)+1 && strings.HasSuffix(host, "."+strings.ToLower(suffix)) {
+			return allowed, true
+		}
+	}
+	return "", false
+}
+
+// allows reports whether value is in list, or list contains "*"
+func allows(list []string, value string) bool {
+	for _, item := range list {
+		if item == "*" || strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsSafelistedMethods never need a preflight
+var corsSafelistedMethods = []string{"GET", "HEAD", "POST"}
+
+// corsSafelistedHeaders are request headers a browser sends without a
+// preflight. Content-Type is safelisted only for corsSimpleContentTypes.
+var corsSafelistedHeaders = []string{"Accept", "Accept-Language", "Content-Language"}
+
+var corsSimpleContentTypes = []string{"application/x-www-form-urlencoded", "multipart/form-data", "text/plain"}
+
+// corsRequest describes a cross-origin request as the browser sees it
+type corsRequest struct {
+	Origin      string   `json:"origin"`
+	Method      string   `json:"method"`
+	Headers     []string `json:"headers"`
+	ContentType string   `json:"content_type,omitempty"`
+	Credentials bool     `json:"credentials"`
+}
+
+// preflightReasons returns why a browser would send a preflight for req,
+// which is none for a simple request
+func (req corsRequest) preflightReasons() []string {
+	reasons := []string{}
+	if !slices.Contains(corsSafelistedMethods, req.Method) {
+		reasons = append(reasons, fmt.Sprintf("%s is not a CORS-safelisted method", req.Method))
+	}
+	for _, name := range req.Headers {
+		if !allows(corsSafelistedHeaders, name) {
+			reasons = append(reasons, fmt.Sprintf("%s is not a CORS-safelisted request header", http.CanonicalHeaderKey(name)))
+		}
+	}
+	if req.ContentType != "" {
+		mediaType, _, _ := strings.Cut(req.ContentType, ";")
+		if !allows(corsSimpleContentTypes, strings.TrimSpace(mediaType)) {
+			reasons = append(reasons, fmt.Sprintf("Content-Type %s is not a CORS-safelisted value", req.ContentType))
+		}
+	}
+	return reasons
+}
+
+// requestedHeaders returns the headers listed in Access-Control-Request-Headers
+// for req
+func (req corsRequest) requestedHeaders() []string {
+	var headers []string
+	for _, name := range req.Headers {
+		if !allows(corsSafelistedHeaders, name) {
+			headers = append(headers, strings.ToLower(name))
+		}
+	}
+	if req.ContentType != "" && !slices.Contains(headers, "content-type") {
+		mediaType, _, _ := strings.Cut(req.ContentType, ";")
+		if !allows(corsSimpleContentTypes, strings.TrimSpace(mediaType)) {
+			headers = append(headers, "content-type")
+		}
+	}
+	return headers
+}
+
+// CORSCheck is one step of the browser's CORS check
+type CORSCheck struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// CORSResult is the outcome of checking a request against a policy. Reason
+// is the detail of the first failed check.
+type CORSResult struct {
+	Origin    string      `json:"origin"`
+	Preflight bool        `json:"preflight"`
+	Method    string      `json:"method,omitempty"`
+	Headers   []string    `json:"headers,omitempty"`
+	Allowed   bool        `json:"allowed"`
+	Reason    string      `json:"reason,omitempty"`
+	Checks    []CORSCheck `json:"checks,omitempty"`
+}
+
+func (result *CORSResult) add(check string, passed bool, detail string) {
+	result.Checks = append(result.Checks, CORSCheck{Check: check, Passed: passed, Detail: detail})
+	if !passed && result.Allowed {
+		result.Allowed = false
+		result.Reason = detail
+	}
+}
+
+// originAllowed reports whether the origin check of the result passed
+func (result *CORSResult) originAllowed() bool {
+	return len(result.Checks) > 0 && result.Checks[0].Check == "origin" && result.Checks[0].Passed
+}
+
+// evaluate checks req the way a browser would against the headers p sends.
+// Method and header checks only apply when a preflight is sent.
+func (p *CORSPolicy) evaluate(req corsRequest, preflight bool) *CORSResult {
+	result := &CORSResult{Origin: req.Origin, Preflight: preflight, Allowed: true}
+	if preflight {
+		result.Method = req.Method
+		result.Headers = req.requestedHeaders()
+	}
+
+	switch {
+	case p == nil:
+		result.add("origin", false, "CORS is not enabled for this path, so no Access-Control-Allow-Origin is sent")
+		return result
+	case req.Origin == "":
+		result.add("origin", false, "no Origin header, so this is not a cross-origin request")
+		return result
+	}
+	if allowed, ok := p.matchOrigin(req.Origin); ok {
+		result.add("origin", true, fmt.Sprintf("%s matches %q in allow_origins", req.Origin, allowed))
+	} else {
+		result.add("origin", false, fmt.Sprintf("%s is not in allow_origins [%s]", req.Origin, strings.Join(p.AllowOrigins, ", ")))
+		return result
+	}
+
+	if req.Credentials {
+		if p.AllowCredentials {
+			result.add("credentials", true, "Access-Control-Allow-Credentials: true is sent with the request origin")
+		} else {
+			result.add("credentials", false, "credentials are included but allow_credentials is false")
+		}
+	}
+
+	if !preflight {
+		return result
+	}
+	switch {
+	case allows(p.AllowMethods, req.Method):
+		result.add("method", true, fmt.Sprintf("%s is in allow_methods", req.Method))
+	case slices.Contains(corsSafelistedMethods, req.Method):
+		result.add("method", true, fmt.Sprintf("%s is a CORS-safelisted method", req.Method))
+	default:
+		result.add("method", false, fmt.Sprintf("%s is not in allow_methods [%s]", req.Method, strings.Join(p.AllowMethods, ", ")))
+	}
+	for _, name := range result.Headers {
+		if allows(p.AllowHeaders, name) {
+			result.add("header", true, fmt.Sprintf("%s is in allow_headers", http.CanonicalHeaderKey(name)))
+		} else {
+			result.add("header", false, fmt.Sprintf("%s is not in allow_headers [%s]", http.CanonicalHeaderKey(name), strings.Join(p.AllowHeaders, ", ")))
+		}
+	}
+	return result
+}
+
+// variesByOrigin reports whether responses depend on the Origin header,
+// which is the case unless every origin gets "*"
+func (p *CORSPolicy) variesByOrigin() bool {
+	return !slices.Contains(p.AllowOrigins, "*") || p.AllowCredentials
+}
+
+// responseHeaders returns the CORS headers sent for req. Only Vary is sent
+// when the origin is not allowed, so that caches keep the responses for
+// each origin apart. A "*" in methods or headers is answered with the
+// requested values so that it also works with credentials.
+func (p *CORSPolicy) responseHeaders(req corsRequest, result *CORSResult) http.Header {
+	header := http.Header{}
+	if p == nil {
+		return header
+	}
+	if p.variesByOrigin() {
+		header.Set("Vary", "Origin")
+	}
+	if !result.originAllowed() {
+		return header
+	}
+
+	if p.variesByOrigin() {
+		header.Set("Access-Control-Allow-Origin", req.Origin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", "*")
+	}
+	if p.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !result.Preflight {
+		if len(p.ExposeHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(p.ExposeHeaders, ", "))
+		}
+		return header
+	}
+	if slices.Contains(p.AllowMethods, "*") {
+		header.Set("Access-Control-Allow-Methods", req.Method)
+	} else if len(p.AllowMethods) > 0 {
+		header.Set("Access-Control-Allow-Methods", strings.Join(p.AllowMethods, ", "))
+	}
+	if slices.Contains(p.AllowHeaders, "*") {
+		if len(result.Headers) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(result.Headers, ", "))
+		}
+	} else if len(p.AllowHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(p.AllowHeaders, ", "))
+	}
+	if p.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+	}
+	return header
+}
+
+type corsKey struct{}
+
+// withCORS applies corsConfig: preflights are answered with 204 and the
+// policy's headers, and other requests with an Origin get the
+// Access-Control-Allow-Origin headers. X-CORS-Result reports the outcome.
+// Requests on paths without a policy, including preflights, reach next as
+// they would reach a service without CORS support.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		policy := corsConfig.PolicyFor(r.URL.Path)
+		if policy == nil || origin == "" {
+			if policy != nil && policy.variesByOrigin() {
+				w.Header().Add("Vary", "Origin")
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		req := corsRequest{Origin: origin, Method: r.Method}
+		if preflight {
+			req.Method = r.Header.Get("Access-Control-Request-Method")
+			req.Headers = splitList(strings.Join(r.Header.Values("Access-Control-Request-Headers"), ","))
+		}
+		result := policy.evaluate(req, preflight)
+
+		for name, values := range policy.responseHeaders(req, result) {
+			for _, value := range values {
+				w.Header().Add(name, value)
+			}
+		}
+		if result.Allowed {
+			w.Header().Set("X-CORS-Result", "allowed")
+		} else {
+			w.Header().Set("X-CORS-Result", "rejected: "+result.Reason)
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), corsKey{}, result))
+		if preflight {
+			logAccess(r)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsFromRequest returns the result stored by withCORS, or nil if the
+// request was not checked
+func corsFromRequest(r *http.Request) *CORSResult {
+	result, _ := r.Context().Value(corsKey{}).(*CORSResult)
+	return result
+}
+
+// corsHandler handles /cors requests by explaining whether a cross-origin
+// request would pass the policy for a path, and which headers are sent
+func corsHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	query := r.URL.Query()
+	req := corsRequest{
+		Origin:      query.Get("origin"),
+		Method:      strings.ToUpper(query.Get("method")),
+		Headers:     splitList(query.Get("headers")),
+		ContentType: query.Get("content_type"),
+		Credentials: query.Get("credentials") == "true",
+	}
+	if req.Origin == "" {
+		req.Origin = r.Header.Get("Origin")
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	path := query.Get("path")
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(req.Method, " ,;") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "path must start with / and method must be a single method",
+			"example": "/cors?origin=https://app.example.com&method=PUT&headers=Authorization&path=/api/",
+		})
+		return
+	}
+
+	policy := corsConfig.PolicyFor(path)
+	reasons := req.preflightReasons()
+	preflight := len(reasons) > 0
+	result := policy.evaluate(req, preflight)
+
+	response := map[string]interface{}{
+		"enabled": policy != nil,
+		"path":    path,
+		"policy":  policy,
+		"request": req,
+		"preflight": map[string]interface{}{
+			"required": preflight,
+			"reasons":  reasons,
+		},
+		"allowed": result.Allowed,
+		"checks":  result.Checks,
+	}
+	if !result.Allowed {
+		response["reason"] = result.Reason
+	}
+	if preflight {
+		response["preflight_response_headers"] = policy.responseHeaders(req, result)
+	}
+	actual := policy.evaluate(req, false)
+	response["response_headers"] = policy.responseHeaders(req, actual)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
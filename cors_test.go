@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// withCORSConfig sets corsConfig for the duration of a test
+func withCORSConfig(t *testing.T, policy CORSPolicy, routes string) {
+	var routesPath string
+	if routes != "" {
+		routesPath = filepath.Join(t.TempDir(), "cors.json")
+		if err := os.WriteFile(routesPath, []byte(routes), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := NewCORSConfig(policy, routesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := corsConfig
+	corsConfig = config
+	t.Cleanup(func() { corsConfig = original })
+}
+
+func TestCORSPolicy_MatchOrigin(t *testing.T) {
+	p := &CORSPolicy{AllowOrigins: []string{"https://app.example.com", "https://*.example.org"}}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"http://app.example.com", false},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		if _, got := p.matchOrigin(tt.origin); got != tt.want {
+			t.Errorf("matchOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+	if _, ok := (&CORSPolicy{AllowOrigins: []string{"*"}}).matchOrigin("null"); ok {
+		t.Error("* should not match the null origin")
+	}
+}
+
+func TestNewCORSConfig(t *testing.T) {
+	withCORSConfig(t, CORSPolicy{AllowOrigins: []string{"*"}, AllowMethods: []string{"GET"}},
+		`{"/api/": {"allow_origins": ["https://app.example.com"], "allow_credentials": true}, "/ping": null}`)
+
+	if p := corsConfig.PolicyFor("/api/users"); p == nil || !p.AllowCredentials || p.AllowMethods[0] != "GET" {
+		t.Errorf("unexpected route policy: %+v", p)
+	}
+	if p := corsConfig.PolicyFor("/ping"); p != nil {
+		t.Errorf("expected CORS to be disabled, got %+v", p)
+	}
+	if p := corsConfig.PolicyFor("/"); p == nil || p.AllowOrigins[0] != "*" {
+		t.Errorf("unexpected default policy: %+v", p)
+	}
+
+	for _, origins := range []string{"app.example.com", "https://app.example.com/path"} {
+		if _, err := NewCORSConfig(CORSPolicy{AllowOrigins: []string{origins}}, ""); err == nil {
+			t.Errorf("%s: expected an error", origins)
+		}
+	}
+}
+
+func TestWithCORS_Preflight(t *testing.T) {
+	withCORSConfig(t, CORSPolicy{
+		AllowOrigins:     []string{"https://app.example.com"},
+		AllowMethods:     []string{"GET", "PUT"},
+		AllowHeaders:     []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}, "")
+	logger = NewAccessLogger(100)
+	handler := newHandler()
+
+	tests := []struct {
+		origin  string
+		method  string
+		headers string
+		allowed bool
+	}{
+		{"https://app.example.com", "PUT", "content-type", true},
+		{"https://app.example.com", "DELETE", "", false},
+		{"https://app.example.com", "PUT", "content-type, x-custom", false},
+		{"https://evil.test", "PUT", "", false},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("OPTIONS", "/", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", tt.method)
+		if tt.headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", tt.headers)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNoContent || rr.Body.Len() != 0 {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+		if got := rr.Header().Get("X-CORS-Result") == "allowed"; got != tt.allowed {
+			t.Errorf("%s %s: X-CORS-Result %q", tt.origin, tt.method, rr.Header().Get("X-CORS-Result"))
+		}
+
+		logs := logger.GetLogs()
+		if cors := logs[len(logs)-1].CORS; cors == nil || !cors.Preflight || cors.Allowed != tt.allowed {
+			t.Errorf("%s %s: unexpected log entry %+v", tt.origin, tt.method, cors)
+		}
+	}
+
+	req, _ := http.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, PUT",
+		"Access-Control-Allow-Headers":     "Content-Type",
+		"Access-Control-Max-Age":           "600",
+		"Vary":                             "Origin",
+	}
+	for name, value := range want {
+		if got := rr.Header().Get(name); got != value {
+			t.Errorf("%s got %q want %q", name, got, value)
+		}
+	}
+}
+
+func TestWithCORS_Request(t *testing.T) {
+	withCORSConfig(t, CORSPolicy{AllowOrigins: []string{"*"}, ExposeHeaders: []string{"X-Request-Id"}}, `{"/ping": null}`)
+	handler := newHandler()
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin got %q want %q", got, "*")
+	}
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-Id" {
+		t.Errorf("Access-Control-Expose-Headers got %q want %q", got, "X-Request-Id")
+	}
+
+	// Disabled routes reach the handler, preflights included, as they would
+	// reach a service without CORS support
+	for _, acrm := range []string{"PUT", ""} {
+		req, _ = http.NewRequest("OPTIONS", "/ping", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		if acrm != "" {
+			req.Header.Set("Access-Control-Request-Method", acrm)
+		}
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Body.String() != "pong" || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("unexpected response: %v %q", rr.Header(), rr.Body.String())
+		}
+	}
+}
+
+func TestWithCORS_VaryOrigin(t *testing.T) {
+	withCORSConfig(t, CORSPolicy{AllowOrigins: []string{"https://app.example.com"}}, `{"/public/": {"allow_origins": ["*"]}}`)
+	handler := newHandler()
+
+	tests := []struct {
+		path   string
+		origin string
+		vary   bool
+	}{
+		{"/", "https://app.example.com", true},
+		{"/", "https://evil.test", true},
+		{"/", "", true},
+		{"/public/", "https://evil.test", false},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		vary := slices.Contains(rr.Header().Values("Vary"), "Origin")
+		if vary != tt.vary {
+			t.Errorf("%s from %q: Vary %v", tt.path, tt.origin, rr.Header().Values("Vary"))
+		}
+	}
+
+	// A rejected origin gets Vary but no Access-Control-Allow-Origin
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://evil.test")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("Vary") != "Origin" || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("unexpected headers for a rejected origin: %v", rr.Header())
+	}
+}
+
+func TestWithCORS_PreflightTraced(t *testing.T) {
+	withCORSConfig(t, CORSPolicy{AllowOrigins: []string{"https://app.example.com"}, AllowMethods: []string{"PUT"}}, "")
+	collector, server := startFakeCollector(t)
+	withExporter(t, NewOTLPExporter(server.URL, "test-service", ""))
+	handler := newHandler()
+
+	req, _ := http.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+	exporter.Flush()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	traces := collector.payloads["/v1/traces"]
+	if len(traces) != 1 {
+		t.Fatalf("expected 1 trace export, got %d", len(traces))
+	}
+	span := first(first(traces[0], "resourceSpans"), "scopeSpans")["spans"].([]interface{})[0]
+	attributes := attributeMap(span.(map[string]interface{})["attributes"])
+	if attributes["http.request.method"] != "OPTIONS" || attributes["http.response.status_code"] != "204" {
+		t.Errorf("unexpected span attributes: %v", attributes)
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	withCORSConfig(t, CORSPolicy{
+		AllowOrigins: []string{"https://app.example.com"},
+		AllowMethods: []string{"GET", "POST"},
+		AllowHeaders: []string{"*"},
+	}, "")
+
+	tests := []struct {
+		query     string
+		preflight bool
+		allowed   bool
+		reason    string
+	}{
+		{"origin=https://app.example.com", false, true, ""},
+		{"origin=https://app.example.com&method=POST&content_type=text/plain", false, true, ""},
+		{"origin=https://app.example.com&method=POST&content_type=application/json", true, true, ""},
+		{"origin=https://app.example.com&method=PUT", true, false, "PUT is not in allow_methods [GET, POST]"},
+		{"origin=https://app.example.com&credentials=true", false, false, "credentials are included but allow_credentials is false"},
+		{"origin=https://evil.test&headers=Authorization", true, false, "https://evil.test is not in allow_origins [https://app.example.com]"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/cors?"+tt.query, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(corsHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var response struct {
+			Preflight struct {
+				Required bool `json:"required"`
+			} `json:"preflight"`
+			Allowed bool   `json:"allowed"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse JSON response: %v", err)
+		}
+		if response.Preflight.Required != tt.preflight || response.Allowed != tt.allowed || response.Reason != tt.reason {
+			t.Errorf("%s: unexpected explanation %s", tt.query, rr.Body.String())
+		}
+	}
+
+	// A "*" in allow_headers is answered with the requested headers
+	req, _ := http.NewRequest("GET", "/cors?method=POST&headers=Authorization,X-Custom", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(corsHandler).ServeHTTP(rr, req)
+	var response struct {
+		PreflightResponseHeaders http.Header `json:"preflight_response_headers"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if got := response.PreflightResponseHeaders.Get("Access-Control-Allow-Headers"); got != "authorization, x-custom" {
+		t.Errorf("unexpected Access-Control-Allow-Headers: %q", got)
+	}
+}
+
+func TestCORSHandler_Disabled(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/cors?origin=https://app.example.com", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(corsHandler).ServeHTTP(rr, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if response["enabled"] != false || response["allowed"] != false {
+		t.Errorf("unexpected response: %v", response)
+	}
+
+	req, _ = http.NewRequest("GET", "/cors?path=api", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(corsHandler).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
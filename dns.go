@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DNS record types supported by /dns
+var dnsTypes = map[string]uint16{
+	"A":     1,
+	"CNAME": 5,
+	"MX":    15,
+	"TXT":   16,
+	"AAAA":  28,
+	"SRV":   33,
+}
+
+// DNS response codes, indexed by RCODE
+var dnsRcodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+// DNSAnswer represents a single resource record returned by a lookup
+type DNSAnswer struct {
+	Name  string  `json:"name"`
+	Type  string  `json:"type"`
+	TTL   *uint32 `json:"ttl,omitempty"`
+	Value string  `json:"value"`
+}
+
+// dnsHandler handles /dns?name=...&type=...&server=... requests
+func dnsHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "name parameter is required",
+			"example": "/dns?name=example.com&type=A",
+		})
+		return
+	}
+
+	if err := validateDNSName(name); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   err.Error(),
+			"example": "/dns?name=example.com&type=A",
+		})
+		return
+	}
+
+	qtype := strings.ToUpper(r.URL.Query().Get("type"))
+	if qtype == "" {
+		qtype = "A"
+	}
+	if _, ok := dnsTypes[qtype]; !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":           fmt.Sprintf("unsupported record type: %s", qtype),
+			"supported_types": []string{"A", "AAAA", "CNAME", "MX", "SRV", "TXT"},
+		})
+		return
+	}
+
+	timeout := 5 * time.Second
+	if timeoutStr := r.URL.Query().Get("timeout"); timeoutStr != "" {
+		var err error
+		timeout, err = time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 || timeout > 30*time.Second {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "timeout must be a duration between 0 and 30s",
+			})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var (
+		answers  []DNSAnswer
+		resolver map[string]interface{}
+		err      error
+	)
+
+	startTime := time.Now()
+	if server := r.URL.Query().Get("server"); server != "" {
+		server = withDefaultPort(server, "53")
+		resolver = map[string]interface{}{
+			"kind":    "server",
+			"address": server,
+		}
+		var transport string
+		answers, transport, err = queryDNSServer(ctx, server, name, qtype)
+		resolver["transport"] = transport
+	} else {
+		resolver = map[string]interface{}{
+			"kind":        "system",
+			"nameservers": systemNameservers(),
+		}
+		answers, err = querySystemResolver(ctx, name, qtype)
+	}
+	latency := time.Since(startTime)
+
+	if answers == nil {
+		answers = []DNSAnswer{}
+	}
+
+	response := map[string]interface{}{
+		"name":      name,
+		"type":      qtype,
+		"resolver":  resolver,
+		"answers":   answers,
+		"latency":   latency.String(),
+		"timestamp": time.Now().Format(time.RFC3339Nano),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		response["error"] = err.Error()
+		w.WriteHeader(http.StatusBadGateway)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// withDefaultPort appends port to addr if it doesn't have one
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// systemNameservers returns the nameservers configured in /etc/resolv.conf
+func systemNameservers() []string {
+	servers := []string{}
+
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return servers
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// querySystemResolver looks up name with the system resolver.
+// The standard library doesn't expose TTLs, so answers have none.
+func querySystemResolver(ctx context.Context, name, qtype string) ([]DNSAnswer, error) {
+	resolver := net.DefaultResolver
+	var answers []DNSAnswer
+
+	switch qtype {
+	case "A", "AAAA":
+		network := "ip4"
+		if qtype == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := resolver.LookupIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			answers = append(answers, DNSAnswer{Name: name, Type: qtype, Value: ip.String()})
+		}
+	case "CNAME":
+		cname, err := resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, DNSAnswer{Name: name, Type: qtype, Value: cname})
+	case "MX":
+		mxs, err := resolver.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			answers = append(answers, DNSAnswer{Name: name, Type: qtype, Value: fmt.Sprintf("%d %s", mx.Pref, mx.Host)})
+		}
+	case "TXT":
+		txts, err := resolver.LookupTXT(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, txt := range txts {
+			answers = append(answers, DNSAnswer{Name: name, Type: qtype, Value: txt})
+		}
+	case "SRV":
+		_, srvs, err := resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			answers = append(answers, DNSAnswer{Name: name, Type: qtype, Value: fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target)})
+		}
+	}
+
+	return answers, nil
+}
+
+// queryDNSServer sends a query directly to server over UDP, retrying over
+// TCP if the response is truncated. It returns the answers and the
+// transport that produced them.
+func queryDNSServer(ctx context.Context, server, name, qtype string) ([]DNSAnswer, string, error) {
+	id := uint16(rand.Intn(1 << 16))
+	query := buildDNSQuery(id, name, dnsTypes[qtype])
+
+	resp, err := exchangeDNS(ctx, "udp", server, query)
+	if err != nil {
+		return nil, "udp", err
+	}
+	transport := "udp"
+	if len(resp) >= 4 && resp[2]&0x02 != 0 {
+		resp, err = exchangeDNS(ctx, "tcp", server, query)
+		if err != nil {
+			return nil, "tcp", err
+		}
+		transport = "tcp"
+	}
+
+	answers, err := parseDNSResponse(resp, id)
+	return answers, transport, err
+}
+
+// exchangeDNS sends query to server and returns the raw response
+func exchangeDNS(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		msg := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(msg, uint16(len(query)))
+		copy(msg[2:], query)
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// buildDNSQuery encodes a recursive query for name
+func buildDNSQuery(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)      // QDCOUNT
+
+	msg = appendDNSName(msg, name)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // IN
+	return msg
+}
+
+// validateDNSName checks that name fits the DNS wire format: non-empty
+// labels of at most 63 bytes and at most 255 bytes in total (RFC 1035
+// section 2.3.4). A trailing dot and the root "." are allowed.
+func validateDNSName(name string) error {
+	if name == "." {
+		return nil
+	}
+	size := 1
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			return fmt.Errorf("name %q has an empty label", name)
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q is longer than 63 bytes", label)
+		}
+		size += len(label) + 1
+	}
+	if size > 255 {
+		return fmt.Errorf("name is longer than 255 bytes")
+	}
+	return nil
+}
+
+// appendDNSName appends name in wire format without compression. name must
+// have been checked with validateDNSName; the root has no labels.
+func appendDNSName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// parseDNSResponse decodes the answer section of a DNS response
+func parseDNSResponse(msg []byte, id uint16) ([]DNSAnswer, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("dns response too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, errors.New("dns response id mismatch")
+	}
+
+	rcode := int(msg[3] & 0x0f)
+	if rcode != 0 {
+		if rcode < len(dnsRcodes) {
+			return nil, fmt.Errorf("dns server returned %s", dnsRcodes[rcode])
+		}
+		return nil, fmt.Errorf("dns server returned rcode %d", rcode)
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next + 4
+	}
+
+	typeNames := make(map[uint16]string, len(dnsTypes))
+	for name, t := range dnsTypes {
+		typeNames[t] = name
+	}
+
+	answers := []DNSAnswer{}
+	for i := 0; i < ancount; i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errors.New("dns answer truncated")
+		}
+		rrtype := binary.BigEndian.Uint16(msg[next:])
+		ttl := binary.BigEndian.Uint32(msg[next+4:])
+		rdlength := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		if rdata+rdlength > len(msg) {
+			return nil, errors.New("dns rdata truncated")
+		}
+		offset = rdata + rdlength
+
+		typeName, ok := typeNames[rrtype]
+		if !ok {
+			continue
+		}
+		value, err := formatDNSRdata(msg, rrtype, rdata, rdlength)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, DNSAnswer{Name: name, Type: typeName, TTL: &ttl, Value: value})
+	}
+
+	return answers, nil
+}
+
+// formatDNSRdata renders the rdata of a record the way dig does
+func formatDNSRdata(msg []byte, rrtype uint16, offset, length int) (string, error) {
+	rdata := msg[offset : offset+length]
+
+	switch rrtype {
+	case 1, 28:
+		if (rrtype == 1 && length != 4) || (rrtype == 28 && length != 16) {
+			return "", errors.New("invalid address record")
+		}
+		return net.IP(rdata).String(), nil
+	case 5:
+		name, _, err := readDNSName(msg, offset)
+		return name, err
+	case 15:
+		if length < 3 {
+			return "", errors.New("invalid MX record")
+		}
+		host, _, err := readDNSName(msg, offset+2)
+		return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(rdata), host), err
+	case 16:
+		var parts []string
+		for i := 0; i < len(rdata); {
+			n := int(rdata[i])
+			if i+1+n > len(rdata) {
+				return "", errors.New("invalid TXT record")
+			}
+			parts = append(parts, string(rdata[i+1:i+1+n]))
+			i += 1 + n
+		}
+		return strings.Join(parts, ""), nil
+	case 33:
+		if length < 7 {
+			return "", errors.New("invalid SRV record")
+		}
+		target, _, err := readDNSName(msg, offset+6)
+		return fmt.Sprintf("%d %d %d %s",
+			binary.BigEndian.Uint16(rdata[0:]),
+			binary.BigEndian.Uint16(rdata[2:]),
+			binary.BigEndian.Uint16(rdata[4:]),
+			target), err
+	}
+	return "", fmt.Errorf("unsupported record type %d", rrtype)
+}
+
+// readDNSName decodes a possibly compressed name at offset and returns it
+// along with the offset just past it
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("dns name truncated")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) {
+				return "", 0, errors.New("dns name truncated")
+			}
+			if jumps++; jumps > 32 {
+				return "", 0, errors.New("dns name compression loop")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("dns name truncated")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// startFakeDNSServer starts a UDP DNS server that answers every query with
+// the records produced by answer. It returns the server address.
+func startFakeDNSServer(t *testing.T, rcode byte, answer func(qname []byte, qtype uint16) [][]byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+
+			// Question starts at offset 12 and ends after qtype and qclass
+			end := 12
+			for query[end] != 0 {
+				end += int(query[end]) + 1
+			}
+			qname := query[12 : end+1]
+			qtype := binary.BigEndian.Uint16(query[end+1:])
+
+			records := answer(qname, qtype)
+			resp := make([]byte, 12)
+			copy(resp, query[:2])
+			resp[2] = 0x81 // QR, RD
+			resp[3] = 0x80 | rcode
+			binary.BigEndian.PutUint16(resp[4:], 1)
+			binary.BigEndian.PutUint16(resp[6:], uint16(len(records)))
+			resp = append(resp, query[12:end+5]...)
+			for _, rr := range records {
+				resp = append(resp, rr...)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// fakeRecord encodes a resource record whose owner name points at the question
+func fakeRecord(rrtype uint16, ttl uint32, rdata []byte) []byte {
+	rr := []byte{0xc0, 12}
+	rr = binary.BigEndian.AppendUint16(rr, rrtype)
+	rr = binary.BigEndian.AppendUint16(rr, 1)
+	rr = binary.BigEndian.AppendUint32(rr, ttl)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+	return append(rr, rdata...)
+}
+
+func doDNSRequest(t *testing.T, params url.Values) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, err := http.NewRequest("GET", "/dns?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(dnsHandler)
+	handler.ServeHTTP(rr, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	return rr, response
+}
+
+func TestDNSHandler_SystemResolver(t *testing.T) {
+	rr, response := doDNSRequest(t, url.Values{"name": {"localhost"}, "type": {"A"}})
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v (%v)",
+			status, http.StatusOK, response)
+	}
+
+	resolver, ok := response["resolver"].(map[string]interface{})
+	if !ok || resolver["kind"] != "system" {
+		t.Errorf("unexpected resolver: %v", response["resolver"])
+	}
+
+	answers, ok := response["answers"].([]interface{})
+	if !ok || len(answers) == 0 {
+		t.Fatalf("expected answers, got %v", response["answers"])
+	}
+	answer := answers[0].(map[string]interface{})
+	if answer["value"] != "127.0.0.1" {
+		t.Errorf("unexpected answer: %v", answer)
+	}
+	if _, ok := answer["ttl"]; ok {
+		t.Error("system resolver answers should not have a ttl")
+	}
+
+	if _, ok := response["latency"]; !ok {
+		t.Error("response missing latency field")
+	}
+}
+
+func TestDNSHandler_Server(t *testing.T) {
+	server := startFakeDNSServer(t, 0, func(qname []byte, qtype uint16) [][]byte {
+		switch qtype {
+		case 1:
+			return [][]byte{fakeRecord(1, 300, []byte{10, 0, 0, 1})}
+		case 15:
+			return [][]byte{fakeRecord(15, 60, append([]byte{0, 10}, qname...))}
+		case 16:
+			return [][]byte{fakeRecord(16, 30, []byte("\x05hello\x06 world"))}
+		}
+		return nil
+	})
+
+	tests := []struct {
+		qtype string
+		ttl   float64
+		value string
+	}{
+		{"A", 300, "10.0.0.1"},
+		{"MX", 60, "10 svc.example.com."},
+		{"TXT", 30, "hello world"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qtype, func(t *testing.T) {
+			rr, response := doDNSRequest(t, url.Values{
+				"name":   {"svc.example.com"},
+				"type":   {tt.qtype},
+				"server": {server},
+			})
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v (%v)",
+					status, http.StatusOK, response)
+			}
+
+			resolver := response["resolver"].(map[string]interface{})
+			if resolver["address"] != server || resolver["transport"] != "udp" {
+				t.Errorf("unexpected resolver: %v", resolver)
+			}
+
+			answers := response["answers"].([]interface{})
+			if len(answers) != 1 {
+				t.Fatalf("expected 1 answer, got %v", answers)
+			}
+			answer := answers[0].(map[string]interface{})
+			if answer["value"] != tt.value {
+				t.Errorf("unexpected value: got %v want %v", answer["value"], tt.value)
+			}
+			if answer["ttl"] != tt.ttl {
+				t.Errorf("unexpected ttl: got %v want %v", answer["ttl"], tt.ttl)
+			}
+			if answer["name"] != "svc.example.com." {
+				t.Errorf("unexpected name: %v", answer["name"])
+			}
+		})
+	}
+}
+
+func TestDNSHandler_NXDomain(t *testing.T) {
+	server := startFakeDNSServer(t, 3, func(qname []byte, qtype uint16) [][]byte {
+		return nil
+	})
+
+	rr, response := doDNSRequest(t, url.Values{"name": {"missing.example.com"}, "server": {server}})
+
+	if status := rr.Code; status != http.StatusBadGateway {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadGateway)
+	}
+	if response["error"] != "dns server returned NXDOMAIN" {
+		t.Errorf("unexpected error: %v", response["error"])
+	}
+}
+
+func TestDNSHandler_InvalidParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params url.Values
+	}{
+		{"missing name", url.Values{}},
+		{"unsupported type", url.Values{"name": {"example.com"}, "type": {"PTR"}}},
+		{"invalid timeout", url.Values{"name": {"example.com"}, "timeout": {"1m"}}},
+		{"label too long", url.Values{"name": {strings.Repeat("a", 64) + ".example.com"}}},
+		{"name too long", url.Values{"name": {strings.Repeat(strings.Repeat("a", 63)+".", 4) + "com"}}},
+		{"empty label", url.Values{"name": {"example..com"}}},
+		{"leading dot", url.Values{"name": {".example.com"}}},
+		{"double trailing dot", url.Values{"name": {"example.com.."}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, response := doDNSRequest(t, tt.params)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, http.StatusBadRequest)
+			}
+			if _, ok := response["error"]; !ok {
+				t.Error("response missing error field")
+			}
+		})
+	}
+}
+
+func TestValidateDNSName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"example.com":   true,
+		"example.com.":  true,
+		".":             true,
+		"example..com":  false,
+		".example.com":  false,
+		"example.com..": false,
+		"..":            false,
+	} {
+		if err := validateDNSName(name); (err == nil) != valid {
+			t.Errorf("validateDNSName(%q) = %v, want valid %v", name, err, valid)
+		}
+	}
+}
@@ -65,6 +65,10 @@ func TestIntegration(t *testing.T) {
 		testLogsEndpoint(t)
 	})
 
+	t.Run("CheckCommand", func(t *testing.T) {
+		testCheckCommand(t)
+	})
+
 	t.Run("PortConfiguration", func(t *testing.T) {
 		testPortConfiguration(t)
 	})
@@ -212,6 +216,22 @@ func testLogsEndpoint(t *testing.T) {
 	}
 }
 
+func testCheckCommand(t *testing.T) {
+	// The scratch image has no shell, so the binary itself must do the check
+	checkCmd := exec.Command("docker", "exec", containerName,
+		"/debug-httpd", "check", fmt.Sprintf("http://localhost:%s/ping", testPort))
+	output, err := checkCmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("check command failed: %v\nOutput: %s", err, output)
+	}
+
+	checkCmd = exec.Command("docker", "exec", containerName,
+		"/debug-httpd", "check", fmt.Sprintf("http://localhost:%s/status/503", testPort))
+	if err := checkCmd.Run(); err == nil {
+		t.Error("check command should fail for a 503 response")
+	}
+}
+
 func testPortConfiguration(t *testing.T) {
 	// Test with environment variable
 	t.Log("Testing port configuration with environment variable...")
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtKeys verifies JWT signatures. It is configured by the -jwt-secret and
+// -jwks-file flags.
+var jwtKeys = &JWTKeys{}
+
+// JWTKeys holds a shared secret for HS* tokens and public keys from a JWKS
+// for RS*, PS*, ES* and EdDSA tokens
+type JWTKeys struct {
+	secret []byte
+	keys   []jsonWebKey
+}
+
+// jsonWebKey is a public key from a JWKS
+type jsonWebKey struct {
+	kid string
+	kty string
+	key crypto.PublicKey
+}
+
+// LoadJWTKeys creates the key set from a shared secret and the path of a
+// JWKS file, either of which may be empty
+func LoadJWTKeys(secret, jwksPath string) (*JWTKeys, error) {
+	keys := &JWTKeys{secret: []byte(secret)}
+	if jwksPath == "" {
+		return keys, nil
+	}
+
+	data, err := os.ReadFile(jwksPath)
+	if err != nil {
+		return nil, err
+	}
+	keys.keys, err = parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", jwksPath, err)
+	}
+	return keys, nil
+}
+
+// IsEmpty reports whether no secret or keys are configured
+func (k *JWTKeys) IsEmpty() bool {
+	return len(k.secret) == 0 && len(k.keys) == 0
+}
+
+// AddKey adds a public key for verifying RS*, PS*, ES* and EdDSA tokens
+func (k *JWTKeys) AddKey(kid, kty string, key crypto.PublicKey) {
+	k.keys = append(k.keys, jsonWebKey{kid: kid, kty: kty, key: key})
+}
+
+// jwkParams are the JWK members needed to build a public key. Other members
+// such as x5c or key_ops are ignored.
+type jwkParams struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the public keys of a JSON Web Key Set. Encryption keys
+// and keys of unsupported types (e.g. oct) are skipped with a warning, as
+// identity providers often publish them alongside the signing keys.
+func parseJWKS(data []byte) ([]jsonWebKey, error) {
+	var jwks struct {
+		Keys []jwkParams `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	var keys []jsonWebKey
+	for i, k := range jwks.Keys {
+		if k.Use == "enc" {
+			log.Printf("JWKS: skipping key %d (kid %q): encryption key", i, k.Kid)
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			log.Printf("JWKS: skipping key %d (kid %q): %v", i, k.Kid, err)
+			continue
+		}
+		keys = append(keys, jsonWebKey{kid: k.Kid, kty: k.Kty, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable signing keys in %d keys", len(jwks.Keys))
+	}
+	return keys, nil
+}
+
+func parseJWK(k jwkParams) (crypto.PublicKey, error) {
+	params := map[string]string{"n": k.N, "e": k.E, "x": k.X, "y": k.Y}
+	decode := func(name string) ([]byte, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(params[name], "="))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid %q parameter", name)
+		}
+		return b, nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e")
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y")
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode("x")
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid \"x\" parameter")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// decodedJWT is a JWT split into its parts
+type decodedJWT struct {
+	header       map[string]interface{}
+	claims       map[string]interface{}
+	signingInput string
+	signature    []byte
+}
+
+// decodeJWT decodes a compact JWS without verifying it
+func decodeJWT(token string) (*decodedJWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) == 5 {
+		return nil, errors.New("token is an encrypted JWT (JWE), which cannot be decoded")
+	}
+	if len(parts) != 3 {
+		return nil, errors.New("token does not have three dot separated parts")
+	}
+
+	decodePart := func(name, part string) (map[string]interface{}, error) {
+		data, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not valid base64url: %v", name, err)
+		}
+		var v map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			return nil, fmt.Errorf("%s is not a JSON object: %v", name, err)
+		}
+		return v, nil
+	}
+
+	header, err := decodePart("header", parts[0])
+	if err != nil {
+		return nil, err
+	}
+	claims, err := decodePart("claims", parts[1])
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature is not valid base64url: %v", err)
+	}
+
+	return &decodedJWT{
+		header:       header,
+		claims:       claims,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}, nil
+}
+
+// numericClaim returns a NumericDate claim as a time
+func (t *decodedJWT) numericClaim(name string) (time.Time, bool) {
+	n, ok := t.claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// validity describes the exp, nbf and iat claims relative to now
+func (t *decodedJWT) validity(now time.Time) map[string]interface{} {
+	result := map[string]interface{}{"status": "valid"}
+	if iat, ok := t.numericClaim("iat"); ok {
+		result["issued_at"] = iat.UTC().Format(time.RFC3339)
+	}
+	if nbf, ok := t.numericClaim("nbf"); ok {
+		result["not_before"] = nbf.UTC().Format(time.RFC3339)
+		if now.Before(nbf) {
+			result["status"] = "not_yet_valid"
+		}
+	}
+	exp, ok := t.numericClaim("exp")
+	if !ok {
+		if result["status"] == "valid" {
+			result["status"] = "no_expiry"
+		}
+		return result
+	}
+	result["expires_at"] = exp.UTC().Format(time.RFC3339)
+	if now.After(exp) {
+		result["status"] = "expired"
+		result["expired_ago"] = now.Sub(exp).Round(time.Second).String()
+	} else {
+		result["expires_in"] = exp.Sub(now).Round(time.Second).String()
+	}
+	return result
+}
+
+// jwtHashes maps the digest size of an algorithm name to its hash
+var jwtHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// verify checks the signature with the configured keys, returning the ID of
+// the key that verified it
+func (k *JWTKeys) verify(t *decodedJWT) (string, error) {
+	alg, _ := t.header["alg"].(string)
+	kid, _ := t.header["kid"].(string)
+	if alg == "" || alg == "none" {
+		return "", errors.New("token is not signed")
+	}
+
+	if strings.HasPrefix(alg, "HS") {
+		hashFunc, ok := jwtHashes[alg[2:]]
+		if !ok {
+			return "", fmt.Errorf("unsupported algorithm %s", alg)
+		}
+		if len(k.secret) == 0 {
+			return "", errors.New("no shared secret is configured (-jwt-secret)")
+		}
+		mac := hmac.New(func() hash.Hash { return hashFunc.New() }, k.secret)
+		mac.Write([]byte(t.signingInput))
+		if !hmac.Equal(mac.Sum(nil), t.signature) {
+			return "", errors.New("signature does not match the shared secret")
+		}
+		return "", nil
+	}
+
+	if len(k.keys) == 0 {
+		return "", errors.New("no JWKS is configured (-jwks-file)")
+	}
+	var lastErr error = fmt.Errorf("no key in the JWKS matches kid %q", kid)
+	for _, key := range k.keys {
+		if kid != "" && key.kid != kid {
+			continue
+		}
+		err := verifyJWTSignature(alg, key.key, t.signingInput, t.signature)
+		if err == nil {
+			return key.kid, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// verifyJWTSignature checks an asymmetric JWS signature
+func verifyJWTSignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("key type does not match algorithm EdDSA")
+		}
+		if !ed25519.Verify(pub, []byte(signingInput), signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	hashFunc, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	var digest []byte
+	switch hashFunc {
+	case crypto.SHA256:
+		sum := sha256.Sum256([]byte(signingInput))
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384([]byte(signingInput))
+		digest = sum[:]
+	default:
+		sum := sha512.Sum512([]byte(signingInput))
+		digest = sum[:]
+	}
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		if alg[:2] == "RS" {
+			return rsa.VerifyPKCS1v15(pub, hashFunc, digest, signature)
+		}
+		return rsa.VerifyPSS(pub, hashFunc, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %s", alg)
+}
+
+// findJWT returns the token and where it was found: the header named by the
+// header parameter, the cookie named by the cookie parameter, or else the
+// Authorization header
+func findJWT(r *http.Request) (string, string) {
+	query := r.URL.Query()
+	if name := query.Get("header"); name != "" {
+		value := r.Header.Get(name)
+		if scheme, token, ok := strings.Cut(value, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			value = token
+		}
+		return strings.TrimSpace(value), "header:" + http.CanonicalHeaderKey(name)
+	}
+	if name := query.Get("cookie"); name != "" {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return "", "cookie:" + name
+		}
+		return cookie.Value, "cookie:" + name
+	}
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", "authorization"
+	}
+	return strings.TrimSpace(token), "authorization"
+}
+
+// jwtHandler handles /jwt requests by decoding and optionally verifying a
+// JWT from the request
+func jwtHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	token, source := findJWT(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, authRealm))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   fmt.Sprintf("no token found in %s", source),
+			"example": "/jwt, /jwt?header=X-Jwt-Assertion or /jwt?cookie=id_token",
+		})
+		return
+	}
+
+	decoded, err := decodeJWT(token)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  err.Error(),
+			"source": source,
+		})
+		return
+	}
+
+	validity := decoded.validity(time.Now())
+	signature := map[string]interface{}{"verified": false}
+	if jwtKeys.IsEmpty() {
+		signature["error"] = "no key is configured (-jwt-secret or -jwks-file)"
+	} else if kid, err := jwtKeys.verify(decoded); err != nil {
+		signature["error"] = err.Error()
+	} else {
+		signature["verified"] = true
+		if kid != "" {
+			signature["key_id"] = kid
+		}
+	}
+
+	// With require_valid=true, behave like a resource server and reject
+	// tokens that are unverified, expired or not yet valid
+	status := http.StatusOK
+	if r.URL.Query().Get("require_valid") == "true" {
+		if signature["verified"] != true || validity["status"] == "expired" || validity["status"] == "not_yet_valid" {
+			status = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token"`, authRealm))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"source":    source,
+		"header":    decoded.header,
+		"claims":    decoded.claims,
+		"validity":  validity,
+		"signature": signature,
+	})
+}
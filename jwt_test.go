@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeJWT builds a compact JWS, signing it with sign
+func makeJWT(header, claims map[string]interface{}, sign func(signingInput []byte) []byte) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// withJWTKeys replaces jwtKeys for the duration of a test
+func withJWTKeys(t *testing.T, keys *JWTKeys) {
+	original := jwtKeys
+	jwtKeys = keys
+	t.Cleanup(func() { jwtKeys = original })
+}
+
+// inspectJWT runs jwtHandler and decodes its response
+func inspectJWT(t *testing.T, req *http.Request) (int, map[string]interface{}) {
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(jwtHandler)
+	handler.ServeHTTP(rr, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	return rr.Code, response
+}
+
+func TestJWTHandler_HS256(t *testing.T) {
+	withJWTKeys(t, &JWTKeys{secret: []byte("shared-secret")})
+
+	hs256 := func(secret string) func([]byte) []byte {
+		return func(input []byte) []byte {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(input)
+			return mac.Sum(nil)
+		}
+	}
+	claims := map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	req, _ := http.NewRequest("GET", "/jwt", nil)
+	req.Header.Set("Authorization", "Bearer "+makeJWT(map[string]interface{}{"alg": "HS256", "typ": "JWT"}, claims, hs256("shared-secret")))
+	status, response := inspectJWT(t, req)
+
+	if status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if response["claims"].(map[string]interface{})["sub"] != "alice" {
+		t.Errorf("unexpected claims: %v", response["claims"])
+	}
+	if response["validity"].(map[string]interface{})["status"] != "valid" {
+		t.Errorf("unexpected validity: %v", response["validity"])
+	}
+	if response["signature"].(map[string]interface{})["verified"] != true {
+		t.Errorf("signature not verified: %v", response["signature"])
+	}
+
+	// Wrong secret
+	req.Header.Set("Authorization", "Bearer "+makeJWT(map[string]interface{}{"alg": "HS256"}, claims, hs256("other")))
+	_, response = inspectJWT(t, req)
+	if response["signature"].(map[string]interface{})["verified"] != false {
+		t.Errorf("signature with the wrong secret was verified: %v", response["signature"])
+	}
+}
+
+func TestJWTHandler_JWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed-1", "crv": "Ed25519", "x": b64(edPub)},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, jwks, 0o600)
+
+	keys, err := LoadJWTKeys("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withJWTKeys(t, keys)
+
+	claims := map[string]interface{}{"sub": "alice"}
+	tests := []struct {
+		alg  string
+		kid  string
+		sign func([]byte) []byte
+	}{
+		{"RS256", "rsa-1", func(input []byte) []byte {
+			sum := sha256.Sum256(input)
+			sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+			return sig
+		}},
+		{"PS256", "rsa-1", func(input []byte) []byte {
+			sum := sha256.Sum256(input)
+			sig, _ := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, sum[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			return sig
+		}},
+		{"ES256", "ec-1", func(input []byte) []byte {
+			sum := sha256.Sum256(input)
+			r, s, _ := ecdsa.Sign(rand.Reader, ecKey, sum[:])
+			return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}},
+		{"EdDSA", "ed-1", func(input []byte) []byte {
+			return ed25519.Sign(edKey, input)
+		}},
+	}
+
+	for _, tt := range tests {
+		token := makeJWT(map[string]interface{}{"alg": tt.alg, "kid": tt.kid}, claims, tt.sign)
+		req, _ := http.NewRequest("GET", "/jwt", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, response := inspectJWT(t, req)
+
+		signature := response["signature"].(map[string]interface{})
+		if signature["verified"] != true || signature["key_id"] != tt.kid {
+			t.Errorf("%s: unexpected signature result: %v", tt.alg, signature)
+		}
+	}
+}
+
+func TestLoadJWTKeys_X5C(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	// Identity providers publish certificate chains and key_ops as arrays
+	jwks := fmt.Sprintf(`{"keys": [{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": "2024-09-01",
+		"x5t": "bmV2ZXItdXNlZA",
+		"x5c": ["MIIDBTCCAe2gAwIBAgIQ", "MIIDBzCCAe+gAwIBAgIQ"],
+		"key_ops": ["verify"],
+		"n": %q,
+		"e": "AQAB"
+	}]}`, b64(rsaKey.N.Bytes()))
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, []byte(jwks), 0o600)
+
+	keys, err := LoadJWTKeys("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withJWTKeys(t, keys)
+
+	token := makeJWT(map[string]interface{}{"alg": "RS256", "kid": "2024-09-01"}, map[string]interface{}{"sub": "alice"}, func(input []byte) []byte {
+		sum := sha256.Sum256(input)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+		return sig
+	})
+	req, _ := http.NewRequest("GET", "/jwt", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, response := inspectJWT(t, req)
+
+	if signature := response["signature"].(map[string]interface{}); signature["verified"] != true {
+		t.Errorf("unexpected signature result: %v", signature)
+	}
+}
+
+func TestLoadJWTKeys_MixedKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	encKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		{"kty": "EC", "kid": "p192", "crv": "P-192", "x": "AQ", "y": "AQ"},
+		{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": b64(encKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "RSA", "kid": "sig-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, jwks, 0o600)
+
+	keys, err := LoadJWTKeys("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.keys) != 1 || keys.keys[0].kid != "sig-1" {
+		t.Errorf("unexpected keys: %+v", keys.keys)
+	}
+
+	// A JWKS without any usable key is an error
+	jwks, _ = json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": b64(encKey.N.Bytes()), "e": "AQAB"},
+	}})
+	os.WriteFile(path, jwks, 0o600)
+	if _, err := LoadJWTKeys("", path); err == nil {
+		t.Error("expected an error for a JWKS without usable keys")
+	}
+}
+
+func TestJWTHandler_SourcesAndValidity(t *testing.T) {
+	none := func([]byte) []byte { return nil }
+	expired := makeJWT(map[string]interface{}{"alg": "none"}, map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}, none)
+
+	// Named header
+	req, _ := http.NewRequest("GET", "/jwt?header=X-Jwt-Assertion", nil)
+	req.Header.Set("X-Jwt-Assertion", expired)
+	status, response := inspectJWT(t, req)
+	if status != http.StatusOK || response["source"] != "header:X-Jwt-Assertion" {
+		t.Errorf("unexpected response: %v %v", status, response)
+	}
+	if validity := response["validity"].(map[string]interface{}); validity["status"] != "expired" {
+		t.Errorf("unexpected validity: %v", validity)
+	}
+
+	// Cookie, rejected when require_valid is set
+	req, _ = http.NewRequest("GET", "/jwt?cookie=id_token&require_valid=true", nil)
+	req.AddCookie(&http.Cookie{Name: "id_token", Value: expired})
+	status, response = inspectJWT(t, req)
+	if status != http.StatusUnauthorized || response["source"] != "cookie:id_token" {
+		t.Errorf("unexpected response: %v %v", status, response)
+	}
+
+	// Missing and malformed tokens
+	req, _ = http.NewRequest("GET", "/jwt", nil)
+	if status, _ := inspectJWT(t, req); status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+	if status, _ := inspectJWT(t, req); status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestDescribeAuthorization_JWT(t *testing.T) {
+	token := makeJWT(map[string]interface{}{"alg": "none"}, map[string]interface{}{"sub": "alice"}, func([]byte) []byte { return nil })
+	result := describeAuthorization("Bearer " + token)
+
+	jwt, ok := result["jwt"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("bearer JWT was not decoded: %v", result)
+	}
+	if jwt["claims"].(map[string]interface{})["sub"] != "alice" {
+		t.Errorf("unexpected claims: %v", jwt["claims"])
+	}
+}
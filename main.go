@@ -4,13 +4,16 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"html"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -18,6 +21,7 @@ import (
 
 // AccessLog represents a single access log entry
 type AccessLog struct {
+	ID            uint64 `json:"id"`
 	Timestamp     string `json:"timestamp"`
 	Method        string `json:"method"`
 	Path          string `json:"path"`
@@ -26,13 +30,26 @@ type AccessLog struct {
 	UserAgent     string `json:"user_agent"`
 	Referer       string `json:"referer"`
 	Host          string `json:"host"`
+	TraceID       string `json:"trace_id"`
+	SpanID        string `json:"span_id"`
+	ParentSpanID  string `json:"parent_span_id,omitempty"`
+	RequestID     string `json:"request_id"`
+	ChosenStatus  int    `json:"chosen_status,omitempty"`
+	Affinity      string `json:"affinity,omitempty"`
+
+	WebSocket *WebSocketLog `json:"websocket,omitempty"`
+	Transfer  *TransferLog  `json:"transfer,omitempty"`
+	Redirect  *RedirectLog  `json:"redirect,omitempty"`
+	Range     *RangeLog     `json:"range,omitempty"`
+	CORS      *CORSResult   `json:"cors,omitempty"`
 }
 
 // AccessLogger manages access logs with thread safety
 type AccessLogger struct {
-	mu   sync.RWMutex
-	logs []AccessLog
-	size int
+	mu     sync.RWMutex
+	logs   []AccessLog
+	size   int
+	nextID uint64
 }
 
 // NewAccessLogger creates a new access logger with specified size
@@ -43,15 +60,38 @@ func NewAccessLogger(size int) *AccessLogger {
 	}
 }
 
-// Add adds a new log entry
-func (al *AccessLogger) Add(log AccessLog) {
+// Add adds a new log entry and returns its ID
+func (al *AccessLogger) Add(log AccessLog) uint64 {
 	al.mu.Lock()
 	defer al.mu.Unlock()
 
+	al.nextID++
+	log.ID = al.nextID
 	al.logs = append(al.logs, log)
 	if len(al.logs) > al.size {
 		al.logs = al.logs[len(al.logs)-al.size:]
 	}
+	return log.ID
+}
+
+// Update applies fn to the log entry with the given ID. It returns false if
+// the entry has already been evicted.
+func (al *AccessLogger) Update(id uint64, fn func(*AccessLog)) bool {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	for i := len(al.logs) - 1; i >= 0; i-- {
+		if al.logs[i].ID == id {
+			fn(&al.logs[i])
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the number of log entries kept
+func (al *AccessLogger) Size() int {
+	return al.size
 }
 
 // GetLogs returns a copy of all logs
@@ -64,10 +104,14 @@ func (al *AccessLogger) GetLogs() []AccessLog {
 	return result
 }
 
-var logger = NewAccessLogger(100)
+// accessLogSize is the number of requests /logs keeps, and so the window
+// /logs/stats summarizes
+const accessLogSize = 100
+
+var logger = NewAccessLogger(accessLogSize)
 
-// logAccess logs the HTTP request
-func logAccess(r *http.Request) {
+// logAccess logs the HTTP request and returns the ID of the log entry
+func logAccess(r *http.Request) uint64 {
 	host, portStr, _ := net.SplitHostPort(r.RemoteAddr)
 	port, _ := strconv.Atoi(portStr)
 
@@ -81,6 +125,8 @@ func logAccess(r *http.Request) {
 		}
 	}
 
+	trace := traceFromRequest(r)
+
 	log := AccessLog{
 		Timestamp:     time.Now().Format(time.RFC3339Nano),
 		Method:        r.Method,
@@ -90,12 +136,28 @@ func logAccess(r *http.Request) {
 		UserAgent:     r.Header.Get("User-Agent"),
 		Referer:       r.Header.Get("Referer"),
 		Host:          r.Header.Get("Host"),
+		TraceID:       trace.TraceID,
+		SpanID:        trace.SpanID,
+		ParentSpanID:  trace.ParentSpanID,
+		RequestID:     trace.RequestID,
+	}
+	if affinity := affinityFromRequest(r); affinity != nil {
+		log.Affinity = affinity.Status
+	}
+	if cors := corsFromRequest(r); cors != nil {
+		result := *cors
+		result.Checks = nil
+		log.CORS = &result
 	}
 
-	logger.Add(log)
+	log.ID = logger.Add(log)
+	if exporter != nil {
+		exporter.AddLog(log)
+	}
 
 	// Also log to stdout
 	fmt.Printf("[%s] %s %s from %s\n", log.Timestamp, log.Method, requestURI, r.RemoteAddr)
+	return log.ID
 }
 
 // getIPAddresses returns all IP addresses of the host
@@ -183,6 +245,8 @@ func sleepHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setSpanAttribute(r.Context(), "debug_httpd.sleep.duration", duration)
+
 	// Sleep for the specified duration
 	startTime := time.Now()
 	time.Sleep(duration)
@@ -200,10 +264,13 @@ func sleepHandler(w http.ResponseWriter, r *http.Request) {
 
 // statusHandler handles /status/{code} requests with configurable HTTP status code
 func statusHandler(w http.ResponseWriter, r *http.Request) {
-	logAccess(r)
+	logID := logAccess(r)
 
 	// Get status code parameter from path (e.g., /status/404)
 	codeStr := r.URL.Path[len("/status/"):]
+	if codeStr == "" {
+		codeStr = r.URL.Query().Get("codes")
+	}
 	if codeStr == "" {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -214,6 +281,24 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Weighted distribution (e.g., /status/200:90,500:8,503:2)
+	if strings.ContainsAny(codeStr, ",:") {
+		code, err := pickWeightedStatus(r, codeStr)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   err.Error(),
+				"example": "/status/200:90,500:8,503:2?seed=1&by=client",
+			})
+			return
+		}
+		logger.Update(logID, func(log *AccessLog) {
+			log.ChosenStatus = code
+		})
+		codeStr = strconv.Itoa(code)
+	}
+
 	// Parse status code
 	code, err := strconv.Atoi(codeStr)
 	if err != nil {
@@ -236,20 +321,184 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	headers, err := statusHeaders(r.URL.Query())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   err.Error(),
+			"example": "/status/503?Retry-After=5 or /status/503?header=Retry-After:5",
+		})
+		return
+	}
+
+	size, err := parseStatusBodySize(r.URL.Query().Get("size"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	setSpanAttribute(r.Context(), "debug_httpd.fault.status_code", code)
+
 	// Get standard HTTP status text
 	message := http.StatusText(code)
 	if message == "" {
 		message = "Unknown Status Code"
 	}
 
+	body, contentType, err := statusBody(r.URL.Query().Get("format"), code, message, size)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Challenges real services send with these codes, unless overridden
+	switch code {
+	case http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Basic realm="debug-httpd"`)
+	case http.StatusProxyAuthRequired:
+		w.Header().Set("Proxy-Authenticate", `Basic realm="debug-httpd"`)
+	}
+
 	// Return response with the specified status code
-	w.Header().Set("Content-Type", "application/json")
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	for name, values := range headers {
+		w.Header()[name] = values
+	}
 	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status_code": code,
-		"message":     message,
-		"timestamp":   time.Now().Format(time.RFC3339Nano),
-	})
+	w.Write(body)
+}
+
+// maxStatusBodySize is the largest body /status will pad its response to
+const maxStatusBodySize = 100 << 20
+
+// statusReservedHeaders are the framing and hop-by-hop headers /status
+// can't set, as they would break the response or the connection
+var statusReservedHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// statusHeaders collects the response headers requested by a /status query,
+// either as header=Name:Value or as query keys that start with an upper case
+// letter (e.g. Retry-After=30)
+func statusHeaders(query url.Values) (http.Header, error) {
+	headers := http.Header{}
+	for _, h := range query["header"] {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q (expected Name:Value)", h)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	for key, values := range query {
+		if key == "" || key[0] < 'A' || key[0] > 'Z' {
+			continue
+		}
+		for _, value := range values {
+			headers.Add(key, value)
+		}
+	}
+
+	for name, values := range headers {
+		if !isHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if statusReservedHeaders[name] {
+			return nil, fmt.Errorf("header %q can't be set as it controls the framing of the response", name)
+		}
+		for _, value := range values {
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("invalid value for header %q", name)
+			}
+		}
+	}
+	return headers, nil
+}
+
+// isHeaderName reports whether s is a valid header field name (an RFC 9110
+// token)
+func isHeaderName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseStatusBodySize parses the size parameter of /status
+func parseStatusBodySize(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := parseByteSize(s)
+	if err != nil || size > maxStatusBodySize {
+		return 0, fmt.Errorf("size must be a size up to %s", formatByteSize(maxStatusBodySize))
+	}
+	return int(size), nil
+}
+
+// statusBody renders the /status response body in format (json, text,
+// html or empty), padded to at least size bytes
+func statusBody(format string, code int, message string, size int) ([]byte, string, error) {
+	var body []byte
+	var contentType string
+	switch format {
+	case "", "json":
+		response := map[string]interface{}{
+			"status_code": code,
+			"message":     message,
+			"timestamp":   time.Now().Format(time.RFC3339Nano),
+		}
+		body, _ = json.Marshal(response)
+		body = append(body, '\n')
+		if size > len(body) {
+			// Measure the overhead of an empty padding field first
+			response["padding"] = ""
+			withPadding, _ := json.Marshal(response)
+			response["padding"] = strings.Repeat("x", max(0, size-len(withPadding)-1))
+			body, _ = json.Marshal(response)
+			body = append(body, '\n')
+		}
+		return body, "application/json", nil
+	case "text":
+		body = []byte(fmt.Sprintf("%d %s\n", code, message))
+		contentType = "text/plain; charset=utf-8"
+	case "html":
+		body = []byte(fmt.Sprintf("<!DOCTYPE html>\n<html><head><title>%d %s</title></head><body><h1>%d %s</h1></body></html>\n",
+			code, html.EscapeString(message), code, html.EscapeString(message)))
+		contentType = "text/html; charset=utf-8"
+	case "empty":
+		return nil, "", nil
+	default:
+		return nil, "", fmt.Errorf("unknown format %q (supported: json, text, html, empty)", format)
+	}
+
+	if size > len(body) {
+		body = append(body, strings.Repeat("x", size-len(body)-1)+"\n"...)
+	}
+	return body, contentType, nil
 }
 
 // debugHandler handles all other requests with debug information
@@ -270,12 +519,24 @@ func debugHandler(w http.ResponseWriter, r *http.Request) {
 	// Get host information
 	hostname, _ := os.Hostname()
 
+	// Decode credentials, masking secrets
+	authorization := map[string]interface{}{}
+	if value := r.Header.Get("Authorization"); value != "" {
+		authorization["authorization"] = describeAuthorization(value)
+	}
+	if value := r.Header.Get("Proxy-Authorization"); value != "" {
+		authorization["proxy_authorization"] = describeAuthorization(value)
+	}
+	cookies, _ := parseRequestCookies(r)
+
 	// Prepare response
 	response := map[string]interface{}{
 		"timestamp": time.Now().Format(time.RFC3339Nano),
 		"request": map[string]interface{}{
-			"path":    r.URL.Path,
-			"headers": r.Header,
+			"path":          r.URL.Path,
+			"headers":       maskAuthorizationHeaders(r.Header),
+			"authorization": authorization,
+			"cookies":       cookies,
 			"client_address": func() string {
 				host, _, _ := net.SplitHostPort(r.RemoteAddr)
 				return host
@@ -291,20 +552,191 @@ func debugHandler(w http.ResponseWriter, r *http.Request) {
 			"fqdn":         hostname, // In Go, we'd need more complex logic for true FQDN
 			"ip_addresses": getIPAddresses(),
 		},
+		"trace":                 traceFromRequest(r),
 		"environment_variables": envVars,
 		"go_version":            runtime.Version(),
 	}
+	if affinity := affinityFromRequest(r); affinity != nil {
+		response["affinity"] = affinity
+	}
+	encoding := compressionFromRequest(r)
+	compression := compressionInfo(r, encoding)
+	response["compression"] = compression
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	w.Write(encodeWithCompressionSizes(response, compression, encoding))
+}
+
+// envOrDefault returns the value of the environment variable key, or def if
+// it is unset
+func envOrDefault(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// newHandler returns the server handler with all routes registered
+func newHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", pingHandler)
+	mux.HandleFunc("/logs", logsHandler)
+	mux.HandleFunc("/logs/stats", logsStatsHandler)
+	mux.HandleFunc("/sleep/", sleepHandler)
+	mux.HandleFunc("/status/", statusHandler)
+	mux.HandleFunc("/dns", dnsHandler)
+	mux.HandleFunc("/probe/tcp", probeTCPHandler)
+	mux.HandleFunc("/probe/http", probeHTTPHandler)
+	mux.HandleFunc("/chain", chainHandler)
+	mux.HandleFunc("/ws", wsHandler)
+	mux.HandleFunc("/sse", sseHandler)
+	mux.HandleFunc("/stream/", streamHandler)
+	mux.HandleFunc("/drip", dripHandler)
+	mux.HandleFunc("/bytes/", bytesHandler)
+	mux.HandleFunc("/upload", uploadHandler)
+	mux.HandleFunc("/abort/", abortHandler)
+	mux.HandleFunc("/redirect/", redirectHandler)
+	mux.HandleFunc("/relative-redirect/", relativeRedirectHandler)
+	mux.HandleFunc("/redirect-to", redirectToHandler)
+	mux.HandleFunc("/redirect-loop", redirectLoopHandler)
+	mux.HandleFunc("/basic-auth/", basicAuthHandler)
+	mux.HandleFunc("/bearer", bearerHandler)
+	mux.HandleFunc("/digest-auth/", digestAuthHandler)
+	mux.HandleFunc("/jwt", jwtHandler)
+	mux.HandleFunc("/oidc/", oidcHandler)
+	mux.HandleFunc("/cookies", cookiesHandler)
+	mux.HandleFunc("/cookies/set", setCookiesHandler)
+	mux.HandleFunc("/cookies/delete", deleteCookiesHandler)
+	mux.HandleFunc("/gzip", compressedHandler("gzip"))
+	mux.HandleFunc("/deflate", compressedHandler("deflate"))
+	mux.HandleFunc("/brotli", compressedHandler("br"))
+	mux.HandleFunc("/zstd", compressedHandler("zstd"))
+	mux.HandleFunc("/cache/", cacheHandler)
+	mux.HandleFunc("/cache/stats", cacheStatsHandler)
+	mux.HandleFunc("/cors", corsHandler)
+	mux.HandleFunc("/", debugHandler)
+	return withTraceContext(withThrottle(withServerSpan(mux, withCORS(withAffinity(withCompression(mux))))))
 }
 
 func main() {
+	args := os.Args[1:]
+
+	// The first argument selects a subcommand; anything else is handed to
+	// serve so that `debug-httpd 8080` keeps working
+	command := "serve"
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve", "check", "get":
+			command = args[0]
+			args = args[1:]
+		case "help":
+			printUsage(os.Stdout)
+			return
+		}
+	}
+
+	switch command {
+	case "check":
+		os.Exit(runCheck(args, os.Stdout, os.Stderr))
+	case "get":
+		os.Exit(runGet(args, os.Stdout, os.Stderr))
+	default:
+		runServe(args)
+	}
+}
+
+// runServe starts the HTTP server
+func runServe(args []string) {
 	// Parse command line arguments
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
 	var port int
-	flag.IntVar(&port, "port", 0, "Port to listen on")
-	flag.Parse()
+	var probeAllow string
+	var otlpEndpoint, otlpServiceName, otlpHeaders string
+	var otlpInterval time.Duration
+	var throttle, throttleRoutes string
+	var jwtSecret, jwksFile string
+	var oidcEnabled bool
+	var oidcIssuer, oidcUsers string
+	var affinityCookie, affinityCookieAttrs string
+	var compress string
+	var corsOrigins, corsMethods, corsHeaders, corsExposeHeaders, corsRoutes string
+	var corsCredentials bool
+	corsMaxAge, _ := strconv.Atoi(os.Getenv("CORS_MAX_AGE"))
+	fs.IntVar(&port, "port", 0, "Port to listen on")
+	fs.StringVar(&probeAllow, "probe-allow", os.Getenv("PROBE_ALLOW"), "Comma separated list of targets /probe may connect to (host, host:port, *.domain or CIDR; empty allows all)")
+	fs.DurationVar(&probeTimeout, "probe-timeout", probeTimeout, "Default timeout for /probe requests")
+	fs.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector endpoint to export spans and access logs to (e.g. http://localhost:4318)")
+	fs.StringVar(&otlpServiceName, "otlp-service-name", envOrDefault("OTEL_SERVICE_NAME", "debug-httpd"), "service.name resource attribute for exported telemetry")
+	fs.StringVar(&otlpHeaders, "otlp-headers", os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), "Comma separated key=value headers added to OTLP export requests")
+	fs.DurationVar(&otlpInterval, "otlp-interval", 5*time.Second, "Interval between OTLP exports")
+	fs.StringVar(&throttle, "throttle", os.Getenv("THROTTLE"), "Limit the bandwidth of every response (e.g. 56kbps, 10Mbps, 64KiB/s)")
+	fs.StringVar(&jwtSecret, "jwt-secret", os.Getenv("JWT_SECRET"), "Shared secret for verifying HS256/HS384/HS512 tokens on /jwt")
+	fs.StringVar(&jwksFile, "jwks-file", os.Getenv("JWKS_FILE"), "JWKS file with public keys for verifying tokens on /jwt")
+	fs.BoolVar(&oidcEnabled, "oidc", os.Getenv("OIDC") == "true", "Enable the built-in mock OpenID Connect provider under /oidc/")
+	fs.StringVar(&oidcIssuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "Issuer URL of the OIDC provider (default: derived from the request, e.g. http://localhost:9876/oidc)")
+	fs.StringVar(&oidcUsers, "oidc-users", os.Getenv("OIDC_USERS"), "JSON file mapping OIDC user names to their claims")
+	fs.StringVar(&affinityCookie, "affinity-cookie", os.Getenv("AFFINITY_COOKIE"), "Name of a sticky-session cookie holding the hostname to issue and check on every request")
+	fs.StringVar(&affinityCookieAttrs, "affinity-cookie-attrs", envOrDefault("AFFINITY_COOKIE_ATTRS", "Path=/; HttpOnly"), "Attributes of the affinity cookie in Set-Cookie syntax")
+	fs.StringVar(&compress, "compress", os.Getenv("COMPRESS"), "Comma separated encodings to compress responses with based on Accept-Encoding (gzip, deflate or all)")
+	fs.StringVar(&throttleRoutes, "throttle-routes", os.Getenv("THROTTLE_ROUTES"), "Comma separated per-route bandwidth limits by path prefix (e.g. /bytes/=10Mbps,/logs=off)")
+	fs.StringVar(&corsOrigins, "cors-origins", os.Getenv("CORS_ORIGINS"), "Comma separated origins allowed by CORS (*, https://app.example.com or https://*.example.com)")
+	fs.StringVar(&corsMethods, "cors-methods", envOrDefault("CORS_METHODS", defaultCORSMethods), "Comma separated methods allowed by CORS preflights")
+	fs.StringVar(&corsHeaders, "cors-headers", os.Getenv("CORS_HEADERS"), "Comma separated request headers allowed by CORS preflights (* for any)")
+	fs.StringVar(&corsExposeHeaders, "cors-expose-headers", os.Getenv("CORS_EXPOSE_HEADERS"), "Comma separated response headers exposed to cross-origin scripts")
+	fs.BoolVar(&corsCredentials, "cors-credentials", os.Getenv("CORS_CREDENTIALS") == "true", "Allow cross-origin requests with credentials")
+	fs.IntVar(&corsMaxAge, "cors-max-age", corsMaxAge, "Seconds browsers may cache CORS preflight results (0 omits Access-Control-Max-Age)")
+	fs.StringVar(&corsRoutes, "cors-routes", os.Getenv("CORS_ROUTES"), "JSON file mapping path prefixes to CORS policies overriding the flags")
+	fs.Parse(args)
+
+	probeAllowlist = ParseProbeAllowlist(probeAllow)
+	var err error
+	throttleConfig, err = ParseThrottleConfig(throttle, throttleRoutes)
+	if err != nil {
+		log.Fatalf("Invalid throttle configuration: %v", err)
+	}
+	jwtKeys, err = LoadJWTKeys(jwtSecret, jwksFile)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	if oidcEnabled {
+		oidcProvider, err = NewOIDCProvider(oidcIssuer, oidcUsers)
+		if err != nil {
+			log.Fatalf("Failed to start the OIDC provider: %v", err)
+		}
+		// Tokens issued by the provider can be verified on /jwt
+		jwtKeys.AddKey(oidcProvider.kid, "RSA", &oidcProvider.key.PublicKey)
+	}
+	compressionConfig, err = ParseCompressionConfig(compress)
+	if err != nil {
+		log.Fatalf("Invalid compression configuration: %v", err)
+	}
+	if affinityCookie != "" {
+		affinityConfig, err = NewAffinityConfig(affinityCookie, affinityCookieAttrs)
+		if err != nil {
+			log.Fatalf("Invalid affinity cookie configuration: %v", err)
+		}
+	}
+	if corsOrigins != "" || corsRoutes != "" {
+		corsConfig, err = NewCORSConfig(CORSPolicy{
+			AllowOrigins:     splitList(corsOrigins),
+			AllowMethods:     splitList(corsMethods),
+			AllowHeaders:     splitList(corsHeaders),
+			ExposeHeaders:    splitList(corsExposeHeaders),
+			AllowCredentials: corsCredentials,
+			MaxAge:           corsMaxAge,
+		}, corsRoutes)
+		if err != nil {
+			log.Fatalf("Invalid CORS configuration: %v", err)
+		}
+	}
+	if otlpEndpoint != "" {
+		if otlpInterval <= 0 {
+			log.Fatalf("Invalid OTLP export interval: %s (must be positive)", otlpInterval)
+		}
+		exporter = NewOTLPExporter(otlpEndpoint, otlpServiceName, otlpHeaders)
+		exporter.Start(otlpInterval)
+	}
 
 	// If port not specified via flag, check environment variable
 	if port == 0 {
@@ -316,9 +748,9 @@ func main() {
 			}
 		} else {
 			// Check if port is provided as argument
-			if flag.NArg() > 0 {
+			if fs.NArg() > 0 {
 				var err error
-				port, err = strconv.Atoi(flag.Arg(0))
+				port, err = strconv.Atoi(fs.Arg(0))
 				if err != nil {
 					port = 9876
 				}
@@ -328,13 +760,6 @@ func main() {
 		}
 	}
 
-	// Set up routes
-	http.HandleFunc("/ping", pingHandler)
-	http.HandleFunc("/logs", logsHandler)
-	http.HandleFunc("/sleep/", sleepHandler)
-	http.HandleFunc("/status/", statusHandler)
-	http.HandleFunc("/", debugHandler)
-
 	// signal handling for SIGHUP
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP)
@@ -345,13 +770,46 @@ func main() {
 		}
 	}()
 
+	// Send the telemetry still buffered when stopped
+	if exporter != nil {
+		stopCh := make(chan os.Signal, 1)
+		signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-stopCh
+			log.Printf("Received signal: %s, flushing OTLP exports", sig)
+			exporter.Stop()
+			os.Exit(0)
+		}()
+	}
+
 	// Start server
 	addr := fmt.Sprintf(":%d", port)
 	log.Printf("Debug HTTP server starting on port %d", port)
 	log.Printf("Access at http://localhost:%d", port)
+	if !probeAllowlist.IsEmpty() {
+		log.Printf("Probe allowlist: %s", probeAllowlist)
+	}
+	if !throttleConfig.IsEmpty() {
+		log.Printf("Response throttling: %s", throttleConfig)
+	}
+	if len(compressionConfig) > 0 {
+		log.Printf("Response compression: %s", strings.Join(compressionConfig, ", "))
+	}
+	if affinityConfig != nil {
+		log.Printf("Session affinity: %s", affinityConfig)
+	}
+	if corsConfig != nil {
+		log.Printf("CORS: %s", corsConfig)
+	}
+	if oidcProvider != nil {
+		log.Printf("OIDC provider enabled with users: %s", strings.Join(oidcProvider.userNames(), ", "))
+	}
+	if exporter != nil {
+		log.Printf("Exporting spans and access logs to %s", otlpEndpoint)
+	}
 	log.Println("Press Ctrl-C to stop")
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := http.ListenAndServe(addr, newHandler()); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -163,6 +164,29 @@ func TestAccessLogger(t *testing.T) {
 	}
 }
 
+func TestAccessLogger_Update(t *testing.T) {
+	logger := NewAccessLogger(2)
+
+	first := logger.Add(AccessLog{Path: "/first"})
+	second := logger.Add(AccessLog{Path: "/second"})
+	if first == second {
+		t.Fatalf("expected unique IDs, got %d twice", first)
+	}
+
+	if !logger.Update(second, func(log *AccessLog) { log.Method = "POST" }) {
+		t.Error("expected update of existing entry to succeed")
+	}
+	if logs := logger.GetLogs(); logs[1].Method != "POST" || logs[1].ID != second {
+		t.Errorf("entry was not updated: %+v", logs[1])
+	}
+
+	// The first entry is evicted by the size limit
+	logger.Add(AccessLog{Path: "/third"})
+	if logger.Update(first, func(log *AccessLog) { log.Method = "POST" }) {
+		t.Error("expected update of evicted entry to fail")
+	}
+}
+
 func TestGetIPAddresses(t *testing.T) {
 	ips := getIPAddresses()
 	if len(ips) == 0 {
@@ -416,6 +440,127 @@ func TestStatusHandler_MissingCode(t *testing.T) {
 	}
 }
 
+func TestStatusHandler_CustomHeaders(t *testing.T) {
+	req, err := http.NewRequest("GET", "/status/503?Retry-After=5&header=X-Custom:%20hello&header=Cache-Control:no-store", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(statusHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusServiceUnavailable)
+	}
+
+	expected := map[string]string{
+		"Retry-After":   "5",
+		"X-Custom":      "hello",
+		"Cache-Control": "no-store",
+		"Content-Type":  "application/json",
+	}
+	for name, want := range expected {
+		if got := rr.Header().Get(name); got != want {
+			t.Errorf("unexpected %s header: got %q want %q", name, got, want)
+		}
+	}
+}
+
+func TestStatusHandler_DefaultChallenge(t *testing.T) {
+	tests := []struct {
+		path  string
+		name  string
+		value string
+	}{
+		{"/status/401", "WWW-Authenticate", `Basic realm="debug-httpd"`},
+		{"/status/401?WWW-Authenticate=Bearer", "WWW-Authenticate", "Bearer"},
+		{"/status/407", "Proxy-Authenticate", `Basic realm="debug-httpd"`},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(statusHandler)
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get(tt.name); got != tt.value {
+			t.Errorf("%s: unexpected %s header: got %q want %q", tt.path, tt.name, got, tt.value)
+		}
+	}
+}
+
+func TestStatusHandler_BodyFormat(t *testing.T) {
+	tests := []struct {
+		path        string
+		contentType string
+		size        int
+		contains    string
+	}{
+		{"/status/404?format=text", "text/plain; charset=utf-8", 14, "404 Not Found"},
+		{"/status/404?format=html", "text/html; charset=utf-8", -1, "<h1>404 Not Found</h1>"},
+		{"/status/404?format=empty", "", 0, ""},
+		{"/status/404?format=text&size=1KiB", "text/plain; charset=utf-8", 1024, "404 Not Found"},
+		{"/status/500?size=4096", "application/json", 4096, `"status_code":500`},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(statusHandler)
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: unexpected Content-Type: got %q want %q", tt.path, got, tt.contentType)
+		}
+		if tt.size >= 0 && rr.Body.Len() != tt.size {
+			t.Errorf("%s: unexpected body size: got %d want %d", tt.path, rr.Body.Len(), tt.size)
+		}
+		if !strings.Contains(rr.Body.String(), tt.contains) {
+			t.Errorf("%s: body does not contain %q: %s", tt.path, tt.contains, rr.Body.String())
+		}
+	}
+}
+
+func TestStatusHandler_InvalidOptions(t *testing.T) {
+	tests := []string{
+		"/status/503?header=Retry-After",
+		"/status/503?header=Bad%20Name:1",
+		"/status/503?Retry-After=1%0d%0aX-Injected:1",
+		"/status/503?format=xml",
+		"/status/503?size=1GiB",
+		"/status/200?Content-Length=5&size=100",
+		"/status/200?header=transfer-encoding:chunked",
+		"/status/200?Connection=close",
+		"/status/200?header=Trailer:X-Foo",
+	}
+
+	for _, path := range tests {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(statusHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, status, http.StatusBadRequest)
+		}
+	}
+}
+
 func TestStatusHandler_InvalidCode(t *testing.T) {
 	tests := []struct {
 		name string
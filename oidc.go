@@ -0,0 +1,533 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcProvider is the built-in OpenID Connect provider. It is nil unless
+// enabled with the -oidc flag.
+var oidcProvider *OIDCProvider
+
+// oidcTokenLifetime is how long issued access and ID tokens are valid
+const oidcTokenLifetime = time.Hour
+
+// oidcCodeLifetime is how long an authorization code can be exchanged
+const oidcCodeLifetime = time.Minute
+
+// defaultOIDCUsers are the users available when no -oidc-users file is
+// given
+var defaultOIDCUsers = map[string]map[string]interface{}{
+	"alice": {
+		"name":           "Alice",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []interface{}{"admins", "developers"},
+	},
+	"bob": {
+		"name":           "Bob",
+		"email":          "bob@example.com",
+		"email_verified": true,
+		"groups":         []interface{}{"developers"},
+	},
+}
+
+// oidcReservedClaims are set by the provider when issuing tokens and can't be
+// defined in -oidc-users
+var oidcReservedClaims = map[string]bool{
+	"iss": true, "aud": true, "exp": true, "iat": true, "nbf": true, "jti": true,
+	"client_id": true, "scope": true, "auth_time": true, "nonce": true,
+}
+
+// OIDCProvider is a mock OpenID Connect provider that signs tokens with an
+// RSA key generated at startup. Any client ID is accepted and client
+// secrets are not checked.
+type OIDCProvider struct {
+	issuer string
+	key    *rsa.PrivateKey
+	kid    string
+	users  map[string]map[string]interface{}
+
+	mu    sync.Mutex
+	codes map[string]*oidcCode
+}
+
+// oidcCode is an issued authorization code waiting to be exchanged
+type oidcCode struct {
+	clientID            string
+	redirectURI         string
+	user                string
+	nonce               string
+	scope               string
+	codeChallenge       string
+	codeChallengeMethod string
+	expires             time.Time
+}
+
+// NewOIDCProvider creates a provider with a fresh signing key. issuer may be
+// empty to derive it from each request, and usersPath may be empty to use
+// the default users.
+func NewOIDCProvider(issuer, usersPath string) (*OIDCProvider, error) {
+	users := defaultOIDCUsers
+	if usersPath != "" {
+		data, err := os.ReadFile(usersPath)
+		if err != nil {
+			return nil, err
+		}
+		users = nil
+		if err := json.Unmarshal(data, &users); err != nil {
+			return nil, fmt.Errorf("%s: %w", usersPath, err)
+		}
+		if len(users) == 0 {
+			return nil, fmt.Errorf("%s: no users defined", usersPath)
+		}
+		for name, claims := range users {
+			for key := range claims {
+				if oidcReservedClaims[key] {
+					return nil, fmt.Errorf("%s: user %q: claim %q is set by the provider", usersPath, name, key)
+				}
+			}
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCProvider{
+		issuer: strings.TrimSuffix(issuer, "/"),
+		key:    key,
+		kid:    randomHex(8),
+		users:  users,
+		codes:  map[string]*oidcCode{},
+	}, nil
+}
+
+// userNames returns the configured user names in sorted order
+func (p *OIDCProvider) userNames() []string {
+	names := make([]string, 0, len(p.users))
+	for name := range p.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// issuerFor returns the configured issuer, or one derived from the request
+func (p *OIDCProvider) issuerFor(r *http.Request) string {
+	if p.issuer != "" {
+		return p.issuer
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/oidc"
+}
+
+// jwks returns the public signing key as a JSON Web Key Set
+func (p *OIDCProvider) jwks() map[string]interface{} {
+	return map[string]interface{}{
+		"keys": []map[string]interface{}{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": p.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	}
+}
+
+// sign returns claims as an RS256 signed JWT
+func (p *OIDCProvider) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": p.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// userClaims returns the claims of a user, with sub and preferred_username
+// defaulting to the user name
+func (p *OIDCProvider) userClaims(user string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": user, "preferred_username": user}
+	for key, value := range p.users[user] {
+		claims[key] = value
+	}
+	return claims
+}
+
+// userBySubject returns the name of the user with the given sub claim
+func (p *OIDCProvider) userBySubject(sub string) (string, bool) {
+	for name := range p.users {
+		if p.userClaims(name)["sub"] == sub {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// issueCode stores an authorization code, dropping expired ones
+func (p *OIDCProvider) issueCode(code *oidcCode) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for id, c := range p.codes {
+		if now.After(c.expires) {
+			delete(p.codes, id)
+		}
+	}
+	id := randomHex(16)
+	code.expires = now.Add(oidcCodeLifetime)
+	p.codes[id] = code
+	return id
+}
+
+// redeemCode removes and returns an authorization code. Codes can only be
+// used once.
+func (p *OIDCProvider) redeemCode(id string) (*oidcCode, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	code, ok := p.codes[id]
+	delete(p.codes, id)
+	if !ok || time.Now().After(code.expires) {
+		return nil, false
+	}
+	return code, true
+}
+
+// checkPKCE verifies a code_verifier against the stored challenge (RFC 7636)
+func (c *oidcCode) checkPKCE(verifier string) error {
+	if c.codeChallenge == "" {
+		return nil
+	}
+	if verifier == "" {
+		return errors.New("code_verifier is required")
+	}
+	expected := verifier
+	if c.codeChallengeMethod == "S256" {
+		sum := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	if !secureCompare(expected, c.codeChallenge) {
+		return errors.New("code_verifier does not match code_challenge")
+	}
+	return nil
+}
+
+// writeOAuthError sends an OAuth 2.0 error response (RFC 6749 section 5.2)
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// oidcHandler handles the /oidc/ endpoints of the built-in provider
+func oidcHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	p := oidcProvider
+	if p == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "the OIDC provider is disabled; start the server with -oidc",
+		})
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/oidc") {
+	case "/.well-known/openid-configuration":
+		p.discoveryHandler(w, r)
+	case "/jwks":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.jwks())
+	case "/authorize":
+		p.authorizeHandler(w, r)
+	case "/token":
+		p.tokenHandler(w, r)
+	case "/userinfo":
+		p.userinfoHandler(w, r)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     "unknown OIDC endpoint",
+			"discovery": "/oidc/.well-known/openid-configuration",
+		})
+	}
+}
+
+// discoveryHandler serves the OpenID Provider Metadata
+func (p *OIDCProvider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	issuer := p.issuerFor(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email", "groups"},
+		"grant_types_supported":                 []string{"authorization_code", "client_credentials"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+	})
+}
+
+// authorizeHandler signs in the user named by login_hint (or the first
+// user) without prompting, and redirects back with a code. prompt=login or
+// prompt=select_account shows a page to choose the user instead.
+func (p *OIDCProvider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	clientID, redirectURI := query.Get("client_id"), query.Get("redirect_uri")
+	target, err := url.Parse(redirectURI)
+	if clientID == "" || err != nil || !target.IsAbs() {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "client_id and an absolute redirect_uri are required")
+		return
+	}
+
+	// Errors from here on are reported to the client through the redirect
+	fail := func(code, description string) {
+		params := target.Query()
+		params.Set("error", code)
+		params.Set("error_description", description)
+		if state := query.Get("state"); state != "" {
+			params.Set("state", state)
+		}
+		target.RawQuery = params.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}
+
+	if query.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the code response type is supported")
+		return
+	}
+	method := query.Get("code_challenge_method")
+	if query.Get("code_challenge") != "" && method == "" {
+		method = "plain"
+	}
+	if method != "" && method != "S256" && method != "plain" {
+		fail("invalid_request", "code_challenge_method must be S256 or plain")
+		return
+	}
+
+	user := query.Get("login_hint")
+	if user == "" {
+		prompt := query.Get("prompt")
+		if strings.Contains(prompt, "login") || strings.Contains(prompt, "select_account") {
+			p.writeUserChooser(w, r)
+			return
+		}
+		user = p.userNames()[0]
+	}
+	if _, ok := p.users[user]; !ok {
+		fail("access_denied", fmt.Sprintf("unknown user %q", user))
+		return
+	}
+
+	code := p.issueCode(&oidcCode{
+		clientID:            clientID,
+		redirectURI:         redirectURI,
+		user:                user,
+		nonce:               query.Get("nonce"),
+		scope:               query.Get("scope"),
+		codeChallenge:       query.Get("code_challenge"),
+		codeChallengeMethod: method,
+	})
+	params := target.Query()
+	params.Set("code", code)
+	if state := query.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// writeUserChooser renders a page linking to the authorize request for
+// each user
+func (p *OIDCProvider) writeUserChooser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<!DOCTYPE html>\n<html><head><title>debug-httpd sign in</title></head><body>\n<h1>Choose a user</h1>\n<ul>\n")
+	for _, name := range p.userNames() {
+		query := r.URL.Query()
+		query.Del("prompt")
+		query.Set("login_hint", name)
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a></li>\n",
+			html.EscapeString(r.URL.Path+"?"+query.Encode()), html.EscapeString(name))
+	}
+	fmt.Fprint(w, "</ul>\n</body></html>\n")
+}
+
+// tokenHandler exchanges an authorization code or client credentials for
+// tokens
+func (p *OIDCProvider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "the token endpoint requires POST")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID == "" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, authRealm))
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_id is required")
+		return
+	}
+
+	now := time.Now()
+	issuer := p.issuerFor(r)
+	claims := map[string]interface{}{
+		"iss":       issuer,
+		"aud":       clientID,
+		"client_id": clientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(oidcTokenLifetime).Unix(),
+		"jti":       randomHex(16),
+	}
+	response := map[string]interface{}{
+		"token_type": "Bearer",
+		"expires_in": int(oidcTokenLifetime.Seconds()),
+	}
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "client_credentials":
+		claims["sub"] = clientID
+		if scope := r.PostForm.Get("scope"); scope != "" {
+			claims["scope"] = scope
+			response["scope"] = scope
+		}
+	case "authorization_code":
+		code, ok := p.redeemCode(r.PostForm.Get("code"))
+		if !ok {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "the code is invalid, expired or already used")
+			return
+		}
+		if code.clientID != clientID || code.redirectURI != r.PostForm.Get("redirect_uri") {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "client_id or redirect_uri does not match the authorization request")
+			return
+		}
+		if err := code.checkPKCE(r.PostForm.Get("code_verifier")); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+
+		for key, value := range p.userClaims(code.user) {
+			claims[key] = value
+		}
+		if code.scope != "" {
+			claims["scope"] = code.scope
+			response["scope"] = code.scope
+		}
+		if strings.Contains(" "+code.scope+" ", " openid ") {
+			idClaims := p.userClaims(code.user)
+			for _, key := range []string{"iss", "aud", "iat", "exp"} {
+				idClaims[key] = claims[key]
+			}
+			idClaims["auth_time"] = now.Unix()
+			if code.nonce != "" {
+				idClaims["nonce"] = code.nonce
+			}
+			idToken, err := p.sign(idClaims)
+			if err != nil {
+				writeOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+				return
+			}
+			response["id_token"] = idToken
+		}
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type",
+			fmt.Sprintf("grant_type %q is not supported; use authorization_code or client_credentials", grantType))
+		return
+	}
+
+	accessToken, err := p.sign(claims)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	response["access_token"] = accessToken
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// userinfoHandler returns the claims of the user an access token was
+// issued to
+func (p *OIDCProvider) userinfoHandler(w http.ResponseWriter, r *http.Request) {
+	invalid := func(description string) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token"`, authRealm))
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", description)
+	}
+
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, authRealm))
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_request", "missing Bearer token")
+		return
+	}
+	decoded, err := decodeJWT(strings.TrimSpace(token))
+	if err != nil {
+		invalid(err.Error())
+		return
+	}
+	if err := verifyJWTSignature("RS256", &p.key.PublicKey, decoded.signingInput, decoded.signature); err != nil {
+		invalid("the token was not issued by this provider")
+		return
+	}
+	if decoded.validity(time.Now())["status"] == "expired" {
+		invalid("the token has expired")
+		return
+	}
+
+	// Tokens from client_credentials have no user behind them
+	sub, _ := decoded.claims["sub"].(string)
+	claims := map[string]interface{}{"sub": sub}
+	if user, ok := p.userBySubject(sub); ok {
+		claims = p.userClaims(user)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withOIDCProvider enables a provider with the default users for the
+// duration of a test
+func withOIDCProvider(t *testing.T) *OIDCProvider {
+	p, err := NewOIDCProvider("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := oidcProvider
+	oidcProvider = p
+	t.Cleanup(func() { oidcProvider = original })
+	return p
+}
+
+// oidcRequest runs oidcHandler for req
+func oidcRequest(req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(oidcHandler)
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// postToken calls the token endpoint with form values
+func postToken(t *testing.T, form url.Values) (int, map[string]interface{}) {
+	req, _ := http.NewRequest("POST", "http://idp.test/oidc/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := oidcRequest(req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	return rr.Code, response
+}
+
+func TestOIDCHandler_Disabled(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/oidc/.well-known/openid-configuration", nil)
+	if status := oidcRequest(req).Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestOIDCHandler_Discovery(t *testing.T) {
+	p := withOIDCProvider(t)
+
+	req, _ := http.NewRequest("GET", "http://idp.test/oidc/.well-known/openid-configuration", nil)
+	var discovery map[string]interface{}
+	json.Unmarshal(oidcRequest(req).Body.Bytes(), &discovery)
+	if discovery["issuer"] != "http://idp.test/oidc" || discovery["jwks_uri"] != "http://idp.test/oidc/jwks" {
+		t.Errorf("unexpected discovery document: %v", discovery)
+	}
+
+	req, _ = http.NewRequest("GET", "http://idp.test/oidc/jwks", nil)
+	keys, err := parseJWKS(oidcRequest(req).Body.Bytes())
+	if err != nil || len(keys) != 1 || keys[0].kid != p.kid {
+		t.Errorf("unexpected JWKS: %v %v", keys, err)
+	}
+}
+
+func TestOIDCHandler_AuthorizationCodeWithPKCE(t *testing.T) {
+	withOIDCProvider(t)
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	authorize := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"my-app"},
+		"redirect_uri":          {"http://app.test/callback"},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"login_hint":            {"bob"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	req, _ := http.NewRequest("GET", "http://idp.test/oidc/authorize?"+authorize.Encode(), nil)
+	rr := oidcRequest(req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+	}
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	if location.Host != "app.test" || location.Query().Get("state") != "xyz" {
+		t.Fatalf("unexpected redirect: %v", location)
+	}
+	code := location.Query().Get("code")
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {"my-app"},
+		"redirect_uri":  {"http://app.test/callback"},
+		"code_verifier": {"wrong-verifier"},
+	}
+	if status, response := postToken(t, exchange); status != http.StatusBadRequest || response["error"] != "invalid_grant" {
+		t.Errorf("wrong verifier was accepted: %v %v", status, response)
+	}
+
+	// A failed exchange uses up the code
+	req, _ = http.NewRequest("GET", "http://idp.test/oidc/authorize?"+authorize.Encode(), nil)
+	location, _ = url.Parse(oidcRequest(req).Header().Get("Location"))
+	exchange.Set("code", location.Query().Get("code"))
+	exchange.Set("code_verifier", verifier)
+	status, response := postToken(t, exchange)
+	if status != http.StatusOK {
+		t.Fatalf("token endpoint returned wrong status code: got %v want %v (%v)", status, http.StatusOK, response)
+	}
+
+	idToken, err := decodeJWT(response["id_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken.claims["sub"] != "bob" || idToken.claims["nonce"] != "n-0S6" || idToken.claims["aud"] != "my-app" || idToken.claims["iss"] != "http://idp.test/oidc" {
+		t.Errorf("unexpected ID token claims: %v", idToken.claims)
+	}
+	if status, _ := postToken(t, exchange); status != http.StatusBadRequest {
+		t.Errorf("code was redeemed twice")
+	}
+
+	// The access token works on userinfo
+	req, _ = http.NewRequest("GET", "http://idp.test/oidc/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+response["access_token"].(string))
+	rr = oidcRequest(req)
+	var userinfo map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &userinfo)
+	if rr.Code != http.StatusOK || userinfo["email"] != "bob@example.com" {
+		t.Errorf("unexpected userinfo: %v %v", rr.Code, userinfo)
+	}
+}
+
+func TestOIDCHandler_AuthorizeErrors(t *testing.T) {
+	withOIDCProvider(t)
+
+	req, _ := http.NewRequest("GET", "/oidc/authorize?response_type=code", nil)
+	if status := oidcRequest(req).Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	req, _ = http.NewRequest("GET", "/oidc/authorize?response_type=code&client_id=a&redirect_uri=http://app.test/cb&login_hint=mallory&state=s", nil)
+	location, _ := url.Parse(oidcRequest(req).Header().Get("Location"))
+	if location.Query().Get("error") != "access_denied" || location.Query().Get("state") != "s" {
+		t.Errorf("unexpected redirect: %v", location)
+	}
+
+	req, _ = http.NewRequest("GET", "/oidc/authorize?response_type=code&client_id=a&redirect_uri=http://app.test/cb&prompt=login", nil)
+	rr := oidcRequest(req)
+	if !strings.Contains(rr.Body.String(), "login_hint=alice") || !strings.Contains(rr.Body.String(), "login_hint=bob") {
+		t.Errorf("user chooser does not list the users: %s", rr.Body.String())
+	}
+}
+
+func TestOIDCHandler_ClientCredentials(t *testing.T) {
+	p := withOIDCProvider(t)
+	withJWTKeys(t, &JWTKeys{})
+	jwtKeys.AddKey(p.kid, "RSA", &p.key.PublicKey)
+
+	status, response := postToken(t, url.Values{"grant_type": {"client_credentials"}, "client_id": {"worker"}, "scope": {"read"}})
+	if status != http.StatusOK || response["scope"] != "read" || response["id_token"] != nil {
+		t.Fatalf("unexpected token response: %v %v", status, response)
+	}
+
+	// Tokens from the provider verify on /jwt
+	req, _ := http.NewRequest("GET", "/jwt", nil)
+	req.Header.Set("Authorization", "Bearer "+response["access_token"].(string))
+	_, inspected := inspectJWT(t, req)
+	signature := inspected["signature"].(map[string]interface{})
+	if signature["verified"] != true || inspected["claims"].(map[string]interface{})["sub"] != "worker" {
+		t.Errorf("unexpected /jwt result: %v", inspected)
+	}
+
+	if status, response := postToken(t, url.Values{"grant_type": {"password"}, "client_id": {"worker"}}); status != http.StatusBadRequest || response["error"] != "unsupported_grant_type" {
+		t.Errorf("unexpected response: %v %v", status, response)
+	}
+	if status, _ := postToken(t, url.Values{"grant_type": {"client_credentials"}}); status != http.StatusUnauthorized {
+		t.Errorf("token endpoint returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestNewOIDCProvider_ReservedClaims(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	os.WriteFile(path, []byte(`{"carol": {"email": "carol@example.com", "exp": 9999999999}}`), 0o600)
+
+	if _, err := NewOIDCProvider("", path); err == nil || !strings.Contains(err.Error(), `"exp"`) {
+		t.Errorf("expected a reserved claim error, got %v", err)
+	}
+
+	os.WriteFile(path, []byte(`{"carol": {"email": "carol@example.com", "sub": "user-3"}}`), 0o600)
+	if _, err := NewOIDCProvider("", path); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
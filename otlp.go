@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exporter ships spans and access logs to an OTLP collector. It is nil
+// unless -otlp-endpoint is set.
+var exporter *OTLPExporter
+
+// maxOTLPBatch is the number of buffered spans or logs that triggers a flush
+const maxOTLPBatch = 512
+
+// OTLP span kinds and status codes
+const (
+	otlpSpanKindServer  = 2
+	otlpStatusCodeError = 2
+)
+
+// OTLPExporter batches spans and log records and sends them to an OTLP/HTTP
+// collector using the JSON encoding
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	client      *http.Client
+
+	mu    sync.Mutex
+	spans []otlpSpan
+	logs  []otlpLogRecord
+
+	// flushCh asks the flusher goroutine to flush a full batch; stop ends it
+	// after a final flush, which closes done
+	flushCh  chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	started  bool
+	stopOnce sync.Once
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpSpanStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes"`
+	Status            otlpSpanStatus `json:"status"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	TraceID              string         `json:"traceId,omitempty"`
+	SpanID               string         `json:"spanId,omitempty"`
+}
+
+// NewOTLPExporter creates an exporter for the collector at endpoint
+// (e.g. http://localhost:4318). headers is a comma separated list of
+// key=value pairs added to every export request.
+func NewOTLPExporter(endpoint, serviceName, headers string) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		serviceName: serviceName,
+		headers:     map[string]string{},
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flushCh:     make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, pair := range strings.Split(headers, ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			e.headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return e
+}
+
+// Start flushes buffered telemetry every interval, and whenever a batch is
+// full, in a single background goroutine. interval must be positive.
+func (e *OTLPExporter) Start(interval time.Duration) {
+	e.started = true
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-e.flushCh:
+			case <-e.stop:
+				e.Flush()
+				return
+			}
+			e.Flush()
+		}
+	}()
+}
+
+// Stop flushes the remaining telemetry and stops the background goroutine
+func (e *OTLPExporter) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+		if e.started {
+			<-e.done
+		} else {
+			e.Flush()
+		}
+	})
+}
+
+// requestFlush wakes the flusher goroutine without blocking. A flush that is
+// already pending covers the new batch.
+func (e *OTLPExporter) requestFlush() {
+	select {
+	case e.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// AddSpan buffers a finished span
+func (e *OTLPExporter) AddSpan(span otlpSpan) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	full := len(e.spans) >= maxOTLPBatch
+	e.mu.Unlock()
+
+	if full {
+		e.requestFlush()
+	}
+}
+
+// AddLog buffers an access log entry as a log record
+func (e *OTLPExporter) AddLog(entry AccessLog) {
+	timestamp, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	record := otlpLogRecord{
+		TimeUnixNano:         unixNano(timestamp),
+		ObservedTimeUnixNano: unixNano(time.Now()),
+		SeverityNumber:       9,
+		SeverityText:         "INFO",
+		Body:                 otlpString(fmt.Sprintf("%s %s", entry.Method, entry.Path)),
+		Attributes: []otlpKeyValue{
+			otlpStringAttr("http.request.method", entry.Method),
+			otlpStringAttr("url.path", entry.Path),
+			otlpStringAttr("client.address", entry.ClientAddress),
+			otlpIntAttr("client.port", int64(entry.ClientPort)),
+			otlpStringAttr("user_agent.original", entry.UserAgent),
+			otlpStringAttr("http.request.header.referer", entry.Referer),
+			otlpStringAttr("http.request.id", entry.RequestID),
+		},
+		TraceID: entry.TraceID,
+		SpanID:  entry.SpanID,
+	}
+
+	e.mu.Lock()
+	e.logs = append(e.logs, record)
+	full := len(e.logs) >= maxOTLPBatch
+	e.mu.Unlock()
+
+	if full {
+		e.requestFlush()
+	}
+}
+
+// Flush sends all buffered spans and log records to the collector
+func (e *OTLPExporter) Flush() {
+	e.mu.Lock()
+	spans, logs := e.spans, e.logs
+	e.spans, e.logs = nil, nil
+	e.mu.Unlock()
+
+	resource := map[string]interface{}{
+		"attributes": []otlpKeyValue{otlpStringAttr("service.name", e.serviceName)},
+	}
+	scope := map[string]interface{}{"name": "debug-httpd"}
+
+	if len(spans) > 0 {
+		e.post("/v1/traces", map[string]interface{}{
+			"resourceSpans": []interface{}{map[string]interface{}{
+				"resource": resource,
+				"scopeSpans": []interface{}{map[string]interface{}{
+					"scope": scope,
+					"spans": spans,
+				}},
+			}},
+		})
+	}
+
+	if len(logs) > 0 {
+		e.post("/v1/logs", map[string]interface{}{
+			"resourceLogs": []interface{}{map[string]interface{}{
+				"resource": resource,
+				"scopeLogs": []interface{}{map[string]interface{}{
+					"scope":      scope,
+					"logRecords": logs,
+				}},
+			}},
+		})
+	}
+}
+
+// post sends an export request, logging failures
+func (e *OTLPExporter) post(path string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("OTLP export to %s failed: %v", path, err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("OTLP export to %s failed: %v", path, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		log.Printf("OTLP export to %s failed: %v", path, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Printf("OTLP export to %s failed: %s", path, resp.Status)
+	}
+}
+
+// serverSpan collects the attributes of the span for the current request
+type serverSpan struct {
+	mu         sync.Mutex
+	attributes []otlpKeyValue
+	status     int
+}
+
+type serverSpanKey struct{}
+
+// setSpanStatus sets the status code of the server span of the request for
+// responses the ResponseWriter does not see, such as a hijacked 101
+func setSpanStatus(ctx context.Context, code int) {
+	span, _ := ctx.Value(serverSpanKey{}).(*serverSpan)
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	span.status = code
+	span.mu.Unlock()
+}
+
+// setSpanAttribute adds an attribute to the server span of the request, if
+// spans are being exported. value may be a string, int, int64, bool or
+// time.Duration.
+func setSpanAttribute(ctx context.Context, key string, value interface{}) {
+	span, _ := ctx.Value(serverSpanKey{}).(*serverSpan)
+	if span == nil {
+		return
+	}
+
+	var kv otlpKeyValue
+	switch v := value.(type) {
+	case int:
+		kv = otlpIntAttr(key, int64(v))
+	case int64:
+		kv = otlpIntAttr(key, v)
+	case bool:
+		kv = otlpKeyValue{Key: key, Value: otlpAnyValue{BoolValue: &v}}
+	case time.Duration:
+		kv = otlpStringAttr(key, v.String())
+	default:
+		kv = otlpStringAttr(key, fmt.Sprint(v))
+	}
+
+	span.mu.Lock()
+	span.attributes = append(span.attributes, kv)
+	span.mu.Unlock()
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := sr.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijacking")
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// withServerSpan records a server span for each request handled by next,
+// named after the route of mux, when an exporter is configured. It must
+// run inside withTraceContext.
+func withServerSpan(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exporter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		startTime := time.Now()
+		_, route := mux.Handler(r)
+		span := &serverSpan{}
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), serverSpanKey{}, span)))
+
+		tc := traceFromRequest(r)
+		if !tc.Sampled {
+			return
+		}
+
+		status := recorder.status
+		span.mu.Lock()
+		if span.status != 0 {
+			status = span.status
+		}
+		span.mu.Unlock()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		clientAddress, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+		attributes := []otlpKeyValue{
+			otlpStringAttr("http.request.method", r.Method),
+			otlpStringAttr("http.route", route),
+			otlpStringAttr("url.path", r.URL.Path),
+			otlpIntAttr("http.response.status_code", int64(status)),
+			otlpStringAttr("client.address", clientAddress),
+			otlpStringAttr("user_agent.original", r.UserAgent()),
+			otlpStringAttr("http.request.id", tc.RequestID),
+		}
+		span.mu.Lock()
+		attributes = append(attributes, span.attributes...)
+		span.mu.Unlock()
+
+		spanStatus := otlpSpanStatus{}
+		if status >= 500 {
+			spanStatus = otlpSpanStatus{Code: otlpStatusCodeError, Message: http.StatusText(status)}
+		}
+
+		exporter.AddSpan(otlpSpan{
+			TraceID:           tc.TraceID,
+			SpanID:            tc.SpanID,
+			ParentSpanID:      tc.ParentSpanID,
+			TraceState:        tc.TraceState,
+			Name:              r.Method + " " + route,
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: unixNano(startTime),
+			EndTimeUnixNano:   unixNano(time.Now()),
+			Attributes:        attributes,
+			Status:            spanStatus,
+		})
+	})
+}
+
+func otlpString(s string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpStringAttr(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpString(value)}
+}
+
+// otlpIntAttr returns an integer attribute. OTLP/JSON encodes 64-bit
+// integers as strings.
+func otlpIntAttr(key string, value int64) otlpKeyValue {
+	s := strconv.FormatInt(value, 10)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
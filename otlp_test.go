@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCollector records the payloads posted to an OTLP/HTTP endpoint
+type fakeCollector struct {
+	mu       sync.Mutex
+	payloads map[string][]map[string]interface{}
+	headers  http.Header
+}
+
+func startFakeCollector(t *testing.T) (*fakeCollector, *httptest.Server) {
+	collector := &fakeCollector{payloads: map[string][]map[string]interface{}{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("collector could not parse payload: %v", err)
+		}
+		collector.mu.Lock()
+		collector.payloads[r.URL.Path] = append(collector.payloads[r.URL.Path], payload)
+		collector.headers = r.Header
+		collector.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return collector, server
+}
+
+// withExporter installs an exporter for the duration of a test
+func withExporter(t *testing.T, e *OTLPExporter) {
+	saved := exporter
+	exporter = e
+	t.Cleanup(func() { exporter = saved })
+}
+
+// first returns the first element of a nested list in an OTLP payload
+func first(v interface{}, key string) map[string]interface{} {
+	return v.(map[string]interface{})[key].([]interface{})[0].(map[string]interface{})
+}
+
+func attributeMap(v interface{}) map[string]interface{} {
+	attributes := map[string]interface{}{}
+	for _, a := range v.([]interface{}) {
+		kv := a.(map[string]interface{})
+		for _, value := range kv["value"].(map[string]interface{}) {
+			attributes[kv["key"].(string)] = value
+		}
+	}
+	return attributes
+}
+
+func TestOTLPExporter(t *testing.T) {
+	collector, server := startFakeCollector(t)
+	withExporter(t, NewOTLPExporter(server.URL, "test-service", "X-Api-Key=secret"))
+
+	handler := newHandler()
+	for _, path := range []string{"/sleep/10ms", "/status/503"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	exporter.Flush()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	if got := collector.headers.Get("X-Api-Key"); got != "secret" {
+		t.Errorf("export request missing configured header: got %q", got)
+	}
+
+	traces := collector.payloads["/v1/traces"]
+	if len(traces) != 1 {
+		t.Fatalf("expected 1 trace export, got %d", len(traces))
+	}
+	resourceSpans := first(traces[0], "resourceSpans")
+	resource := attributeMap(resourceSpans["resource"].(map[string]interface{})["attributes"])
+	if resource["service.name"] != "test-service" {
+		t.Errorf("unexpected service.name: %v", resource["service.name"])
+	}
+
+	spans := first(resourceSpans, "scopeSpans")["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	sleepSpan := spans[0].(map[string]interface{})
+	if sleepSpan["name"] != "GET /sleep/" || sleepSpan["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || sleepSpan["parentSpanId"] != "00f067aa0ba902b7" {
+		t.Errorf("unexpected sleep span: %v", sleepSpan)
+	}
+	attributes := attributeMap(sleepSpan["attributes"])
+	if attributes["http.route"] != "/sleep/" || attributes["http.response.status_code"] != "200" || attributes["debug_httpd.sleep.duration"] != "10ms" {
+		t.Errorf("unexpected sleep span attributes: %v", attributes)
+	}
+
+	statusSpan := spans[1].(map[string]interface{})
+	attributes = attributeMap(statusSpan["attributes"])
+	if attributes["http.response.status_code"] != "503" || attributes["debug_httpd.fault.status_code"] != "503" {
+		t.Errorf("unexpected status span attributes: %v", attributes)
+	}
+	if code := statusSpan["status"].(map[string]interface{})["code"]; code != float64(otlpStatusCodeError) {
+		t.Errorf("expected error status for a 503, got %v", code)
+	}
+
+	logs := collector.payloads["/v1/logs"]
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log export, got %d", len(logs))
+	}
+	records := first(first(logs[0], "resourceLogs"), "scopeLogs")["logRecords"].([]interface{})
+	if len(records) != 2 {
+		t.Fatalf("expected 2 log records, got %d", len(records))
+	}
+	record := records[0].(map[string]interface{})
+	if record["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || record["spanId"] != sleepSpan["spanId"] {
+		t.Errorf("log record not correlated with span: %v", record)
+	}
+	if attributeMap(record["attributes"])["url.path"] != "/sleep/10ms" {
+		t.Errorf("unexpected log record attributes: %v", record["attributes"])
+	}
+}
+
+func TestOTLPExporter_NotSampled(t *testing.T) {
+	collector, server := startFakeCollector(t)
+	withExporter(t, NewOTLPExporter(server.URL, "test-service", ""))
+
+	req, err := http.NewRequest("GET", "/ping", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	newHandler().ServeHTTP(httptest.NewRecorder(), req)
+	exporter.Flush()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	if len(collector.payloads["/v1/traces"]) != 0 {
+		t.Error("unsampled requests should not export spans")
+	}
+	if len(collector.payloads["/v1/logs"]) != 1 {
+		t.Error("access logs should be exported regardless of sampling")
+	}
+}
+
+func TestOTLPExporter_BatchAndStop(t *testing.T) {
+	collector, server := startFakeCollector(t)
+	e := NewOTLPExporter(server.URL, "test-service", "")
+	e.Start(time.Hour)
+
+	// A full batch is flushed by the background goroutine
+	for i := 0; i < maxOTLPBatch; i++ {
+		e.AddSpan(otlpSpan{Name: "GET /"})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		collector.mu.Lock()
+		n := len(collector.payloads["/v1/traces"])
+		collector.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("full batch was not flushed, got %d payloads", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stop sends what is still buffered
+	e.AddSpan(otlpSpan{Name: "GET /ping"})
+	e.Stop()
+	e.Stop()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	payloads := collector.payloads["/v1/traces"]
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 payloads, got %d", len(payloads))
+	}
+	spans := first(first(payloads[1], "resourceSpans"), "scopeSpans")["spans"].([]interface{})
+	if len(spans) != 1 {
+		t.Errorf("expected 1 span in the final flush, got %d", len(spans))
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Probe settings, configured by command line flags
+var (
+	probeAllowlist = ParseProbeAllowlist("")
+	probeTimeout   = 5 * time.Second
+)
+
+// maxProbeTimeout is the longest timeout a probe request may ask for
+const maxProbeTimeout = 60 * time.Second
+
+var errProbeNotAllowed = errors.New("target is not in the probe allowlist")
+
+// ProbeAllowlist restricts the targets outbound probes may connect to.
+// An empty allowlist allows every target.
+type ProbeAllowlist struct {
+	hosts []string
+	nets  []*net.IPNet
+}
+
+// ParseProbeAllowlist parses a comma separated list of allowed targets.
+// Each entry is a host ("example.com"), a host and port ("example.com:443"),
+// a wildcard domain ("*.svc.cluster.local") or a CIDR ("10.0.0.0/8").
+func ParseProbeAllowlist(s string) *ProbeAllowlist {
+	al := &ProbeAllowlist{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			al.nets = append(al.nets, ipNet)
+			continue
+		}
+		al.hosts = append(al.hosts, entry)
+	}
+	return al
+}
+
+// IsEmpty reports whether the allowlist has no entries
+func (al *ProbeAllowlist) IsEmpty() bool {
+	return len(al.hosts) == 0 && len(al.nets) == 0
+}
+
+// String returns the allowlist entries as a comma separated list
+func (al *ProbeAllowlist) String() string {
+	entries := append([]string{}, al.hosts...)
+	for _, ipNet := range al.nets {
+		entries = append(entries, ipNet.String())
+	}
+	return strings.Join(entries, ",")
+}
+
+// AllowsHost reports whether host:port matches a host entry, or host is an
+// IP address inside an allowed network
+func (al *ProbeAllowlist) AllowsHost(host, port string) bool {
+	if al.IsEmpty() {
+		return true
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range al.hosts {
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryHost == host {
+			return true
+		}
+		if strings.HasPrefix(entryHost, "*.") && strings.HasSuffix(host, entryHost[1:]) {
+			return true
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return al.AllowsIP(ip)
+	}
+	return false
+}
+
+// AllowsIP reports whether ip is inside an allowed network
+func (al *ProbeAllowlist) AllowsIP(ip net.IP) bool {
+	for _, ipNet := range al.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeDialer returns a dialer that enforces the allowlist for host:port.
+// Targets that don't match by name are still allowed when the address
+// they resolve to is inside an allowed network.
+func probeDialer(host, port string, timeout time.Duration) (*net.Dialer, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if probeAllowlist.AllowsHost(host, port) {
+		return dialer, nil
+	}
+	if len(probeAllowlist.nets) == 0 {
+		return nil, errProbeNotAllowed
+	}
+
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		ipStr, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(ipStr); ip == nil || !probeAllowlist.AllowsIP(ip) {
+			return errProbeNotAllowed
+		}
+		return nil
+	}
+	return dialer, nil
+}
+
+// parseProbeTimeout returns the timeout requested by the timeout query
+// parameter, or the configured default
+func parseProbeTimeout(r *http.Request) (time.Duration, error) {
+	timeoutStr := r.URL.Query().Get("timeout")
+	if timeoutStr == "" {
+		return probeTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout format: %v", err)
+	}
+	if timeout <= 0 || timeout > maxProbeTimeout {
+		return 0, fmt.Errorf("timeout must be between 0 and %s", maxProbeTimeout)
+	}
+	return timeout, nil
+}
+
+// probeErrorStatus returns the HTTP status code to report for a failed probe
+func probeErrorStatus(err error) int {
+	if errors.Is(err, errProbeNotAllowed) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// probeTCPHandler handles /probe/tcp?addr=host:port requests
+func probeTCPHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	addr := r.URL.Query().Get("addr")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || port == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "addr parameter must be host:port",
+			"example": "/probe/tcp?addr=example.com:443",
+		})
+		return
+	}
+
+	timeout, err := parseProbeTimeout(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	response := map[string]interface{}{
+		"addr":      addr,
+		"connected": false,
+	}
+	status, err := probeTCP(r.Context(), host, port, timeout, response)
+	response["timestamp"] = time.Now().Format(time.RFC3339Nano)
+	if err != nil {
+		response["error"] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// probeTCP resolves host and connects to it, recording timings in response
+func probeTCP(ctx context.Context, host, port string, timeout time.Duration, response map[string]interface{}) (int, error) {
+	dialer, err := probeDialer(host, port, timeout)
+	if err != nil {
+		return probeErrorStatus(err), err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	dnsTime := time.Since(startTime)
+	response["dns_time"] = dnsTime.String()
+	if err != nil {
+		response["total_time"] = time.Since(startTime).String()
+		return http.StatusBadGateway, err
+	}
+
+	resolved := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		resolved = append(resolved, ip.String())
+	}
+	response["resolved_ips"] = resolved
+
+	// Try each address in turn like net.Dial does
+	connectStart := time.Now()
+	var conn net.Conn
+	for _, ip := range ips {
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil || errors.Is(err, errProbeNotAllowed) {
+			break
+		}
+	}
+	response["connect_time"] = time.Since(connectStart).String()
+	response["total_time"] = time.Since(startTime).String()
+	if err != nil {
+		return probeErrorStatus(err), err
+	}
+	defer conn.Close()
+
+	response["connected"] = true
+	response["local_addr"] = conn.LocalAddr().String()
+	response["remote_addr"] = conn.RemoteAddr().String()
+	return http.StatusOK, nil
+}
+
+// probeHTTPHandler handles /probe/http?url=... requests
+func probeHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	target, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "url parameter must be an absolute http or https URL",
+			"example": "/probe/http?url=https://example.com/",
+		})
+		return
+	}
+
+	timeout, err := parseProbeTimeout(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	method := strings.ToUpper(r.URL.Query().Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	insecure := r.URL.Query().Get("insecure") == "true" || r.URL.Query().Get("insecure") == "1"
+
+	response := map[string]interface{}{
+		"url":    target.String(),
+		"method": method,
+	}
+	status, err := probeHTTP(r.Context(), method, target, timeout, insecure, response)
+	response["timestamp"] = time.Now().Format(time.RFC3339Nano)
+	if err != nil {
+		response["error"] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxProbeBodySize is the most /probe/http reads of a response body
+const maxProbeBodySize = 10 << 20
+
+// probeHTTP sends a single request to target without following redirects,
+// recording timings, the response status and headers in response
+func probeHTTP(ctx context.Context, method string, target *url.URL, timeout time.Duration, insecure bool, response map[string]interface{}) (int, error) {
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	dialer, err := probeDialer(target.Hostname(), port, timeout)
+	if err != nil {
+		return probeErrorStatus(err), err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The trace callbacks may still run on the transport's goroutines when
+	// client.Do returns with an error, so the times are guarded by mu
+	var mu sync.Mutex
+	var dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, firstByte time.Time
+	var remoteAddr string
+	record := func(t *time.Time) {
+		mu.Lock()
+		*t = time.Now()
+		mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { record(&dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { record(&dnsDone) },
+		ConnectStart:      func(string, string) { record(&connectStart) },
+		ConnectDone:       func(string, string, error) { record(&connectDone) },
+		TLSHandshakeStart: func() { record(&tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { record(&tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			remoteAddr = info.Conn.RemoteAddr().String()
+			mu.Unlock()
+		},
+		GotFirstResponseByte: func() { record(&firstByte) },
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, target.String(), nil)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	req.Header.Set("User-Agent", "debug-httpd-probe")
+	injectTraceHeaders(ctx, req.Header)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       dialer.DialContext,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: insecure},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	startTime := time.Now()
+	resp, err := client.Do(req)
+
+	mu.Lock()
+	timings := map[string]interface{}{}
+	if !dnsStart.IsZero() && !dnsDone.IsZero() {
+		timings["dns"] = dnsDone.Sub(dnsStart).String()
+	}
+	if !connectStart.IsZero() && !connectDone.IsZero() {
+		timings["connect"] = connectDone.Sub(connectStart).String()
+	}
+	if !tlsStart.IsZero() && !tlsDone.IsZero() {
+		timings["tls_handshake"] = tlsDone.Sub(tlsStart).String()
+	}
+	if !firstByte.IsZero() {
+		timings["first_byte"] = firstByte.Sub(startTime).String()
+	}
+	response["timings"] = timings
+	if remoteAddr != "" {
+		response["remote_addr"] = remoteAddr
+	}
+	mu.Unlock()
+
+	if err != nil {
+		timings["total"] = time.Since(startTime).String()
+		return probeErrorStatus(err), err
+	}
+	defer resp.Body.Close()
+
+	// Only read up to maxProbeBodySize so that a large or endless body
+	// doesn't keep the probe running
+	bodySize, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxProbeBodySize+1))
+	timings["total"] = time.Since(startTime).String()
+	if bodySize > maxProbeBodySize {
+		bodySize = maxProbeBodySize
+		response["body_truncated"] = true
+	}
+
+	response["status_code"] = resp.StatusCode
+	response["status"] = resp.Status
+	response["proto"] = resp.Proto
+	response["headers"] = resp.Header
+	response["body_size"] = bodySize
+	if resp.TLS != nil {
+		response["tls"] = map[string]interface{}{
+			"version":      tls.VersionName(resp.TLS.Version),
+			"cipher_suite": tls.CipherSuiteName(resp.TLS.CipherSuite),
+			"server_name":  resp.TLS.ServerName,
+		}
+	}
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	return http.StatusOK, nil
+}
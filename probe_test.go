@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func doProbeRequest(t *testing.T, handler http.HandlerFunc, path string, params url.Values) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, err := http.NewRequest("GET", path+"?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	return rr, response
+}
+
+// withProbeAllowlist replaces the probe allowlist for the duration of a test
+func withProbeAllowlist(t *testing.T, s string) {
+	saved := probeAllowlist
+	probeAllowlist = ParseProbeAllowlist(s)
+	t.Cleanup(func() { probeAllowlist = saved })
+}
+
+func TestProbeAllowlist(t *testing.T) {
+	al := ParseProbeAllowlist("example.com, api.example.net:443, *.svc.cluster.local, 10.0.0.0/8")
+
+	tests := []struct {
+		host    string
+		port    string
+		allowed bool
+	}{
+		{"example.com", "80", true},
+		{"EXAMPLE.COM.", "443", true},
+		{"api.example.net", "443", true},
+		{"api.example.net", "80", false},
+		{"my-svc.default.svc.cluster.local", "9876", true},
+		{"svc.cluster.local", "9876", false},
+		{"10.1.2.3", "22", true},
+		{"192.168.0.1", "80", false},
+		{"other.com", "80", false},
+	}
+
+	for _, tt := range tests {
+		if got := al.AllowsHost(tt.host, tt.port); got != tt.allowed {
+			t.Errorf("AllowsHost(%q, %q) = %v, want %v", tt.host, tt.port, got, tt.allowed)
+		}
+	}
+
+	if !ParseProbeAllowlist("").AllowsHost("anything", "1") {
+		t.Error("empty allowlist should allow every target")
+	}
+}
+
+func TestProbeTCPHandler_Success(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	rr, response := doProbeRequest(t, probeTCPHandler, "/probe/tcp", url.Values{"addr": {ln.Addr().String()}})
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v (%v)",
+			status, http.StatusOK, response)
+	}
+	if response["connected"] != true {
+		t.Errorf("expected connected to be true, got %v", response["connected"])
+	}
+	if response["remote_addr"] != ln.Addr().String() {
+		t.Errorf("unexpected remote_addr: got %v want %v", response["remote_addr"], ln.Addr())
+	}
+	for _, field := range []string{"dns_time", "connect_time", "total_time", "resolved_ips"} {
+		if _, ok := response[field]; !ok {
+			t.Errorf("response missing %s field", field)
+		}
+	}
+}
+
+func TestProbeTCPHandler_ConnectionRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	rr, response := doProbeRequest(t, probeTCPHandler, "/probe/tcp", url.Values{"addr": {addr}})
+
+	if status := rr.Code; status != http.StatusBadGateway {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadGateway)
+	}
+	if response["connected"] != false {
+		t.Errorf("expected connected to be false, got %v", response["connected"])
+	}
+	if _, ok := response["error"]; !ok {
+		t.Error("response missing error field")
+	}
+}
+
+func TestProbeTCPHandler_NotAllowed(t *testing.T) {
+	withProbeAllowlist(t, "example.com")
+
+	rr, _ := doProbeRequest(t, probeTCPHandler, "/probe/tcp", url.Values{"addr": {"127.0.0.1:80"}})
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+}
+
+func TestProbeTCPHandler_AllowedByResolvedAddress(t *testing.T) {
+	withProbeAllowlist(t, "127.0.0.0/8")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	rr, response := doProbeRequest(t, probeTCPHandler, "/probe/tcp", url.Values{"addr": {net.JoinHostPort("localhost", port)}})
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v (%v)",
+			status, http.StatusOK, response)
+	}
+}
+
+func TestProbeHTTPHandler_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "yes")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	rr, response := doProbeRequest(t, probeHTTPHandler, "/probe/http", url.Values{"url": {server.URL + "/foo"}})
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v (%v)",
+			status, http.StatusOK, response)
+	}
+	if code, ok := response["status_code"].(float64); !ok || int(code) != http.StatusTeapot {
+		t.Errorf("unexpected status_code: %v", response["status_code"])
+	}
+	if size, ok := response["body_size"].(float64); !ok || size != 5 {
+		t.Errorf("unexpected body_size: %v", response["body_size"])
+	}
+	headers := response["headers"].(map[string]interface{})
+	if _, ok := headers["X-Test"]; !ok {
+		t.Errorf("response headers missing X-Test: %v", headers)
+	}
+	timings := response["timings"].(map[string]interface{})
+	for _, field := range []string{"connect", "first_byte", "total"} {
+		if _, ok := timings[field]; !ok {
+			t.Errorf("timings missing %s field", field)
+		}
+	}
+}
+
+func TestProbeHTTPHandler_BodyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, 64<<10)
+		for written := 0; written <= maxProbeBodySize; written += len(chunk) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	rr, response := doProbeRequest(t, probeHTTPHandler, "/probe/http", url.Values{"url": {server.URL}})
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v (%v)",
+			status, http.StatusOK, response)
+	}
+	if size, ok := response["body_size"].(float64); !ok || size != maxProbeBodySize {
+		t.Errorf("unexpected body_size: %v", response["body_size"])
+	}
+	if truncated, _ := response["body_truncated"].(bool); !truncated {
+		t.Errorf("expected body_truncated to be true: %v", response["body_truncated"])
+	}
+}
+
+func TestProbeHTTPHandler_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	rr, response := doProbeRequest(t, probeHTTPHandler, "/probe/http", url.Values{"url": {server.URL}})
+	if status := rr.Code; status != http.StatusBadGateway {
+		t.Errorf("untrusted certificate: got status %v want %v", status, http.StatusBadGateway)
+	}
+
+	rr, response = doProbeRequest(t, probeHTTPHandler, "/probe/http", url.Values{"url": {server.URL}, "insecure": {"true"}})
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v (%v)",
+			status, http.StatusOK, response)
+	}
+	timings := response["timings"].(map[string]interface{})
+	if _, ok := timings["tls_handshake"]; !ok {
+		t.Error("timings missing tls_handshake field")
+	}
+	if _, ok := response["tls"]; !ok {
+		t.Error("response missing tls field")
+	}
+}
+
+func TestProbeHTTPHandler_InvalidParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params url.Values
+	}{
+		{"missing url", url.Values{}},
+		{"relative url", url.Values{"url": {"/foo"}}},
+		{"unsupported scheme", url.Values{"url": {"ftp://example.com/"}}},
+		{"invalid timeout", url.Values{"url": {"http://example.com/"}, "timeout": {"2m"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, response := doProbeRequest(t, probeHTTPHandler, "/probe/http", tt.params)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, http.StatusBadRequest)
+			}
+			if _, ok := response["error"]; !ok {
+				t.Error("response missing error field")
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRedirects is the longest chain /redirect and /relative-redirect build
+const maxRedirects = 100
+
+// RedirectLog records a step of a redirect chain. Every step of a chain
+// shares the same chain ID.
+type RedirectLog struct {
+	ChainID  string `json:"chain_id"`
+	Hop      int    `json:"hop"`
+	Status   int    `json:"status,omitempty"`
+	Location string `json:"location,omitempty"`
+}
+
+// redirectState is the chain ID, hop and status carried in the query string
+// of each redirect
+type redirectState struct {
+	chainID string
+	hop     int
+	status  int
+}
+
+// parseRedirectState reads the chain state from the query string, starting
+// a new chain if there is none
+func parseRedirectState(query url.Values) (redirectState, error) {
+	state := redirectState{chainID: query.Get("chain"), status: http.StatusFound}
+	if state.chainID == "" {
+		state.chainID = randomHex(8)
+	}
+
+	if statusStr := query.Get("status"); statusStr != "" {
+		status, err := strconv.Atoi(statusStr)
+		if err != nil || !isRedirectStatus(status) {
+			return state, fmt.Errorf("status must be one of 301, 302, 303, 307, 308")
+		}
+		state.status = status
+	}
+
+	if hopStr := query.Get("hop"); hopStr != "" {
+		hop, err := strconv.Atoi(hopStr)
+		if err != nil || hop < 0 {
+			return state, fmt.Errorf("hop must be a non-negative integer")
+		}
+		state.hop = hop
+	}
+	return state, nil
+}
+
+// next returns the query string for the following hop of the chain
+func (s redirectState) next(extra url.Values) string {
+	query := url.Values{}
+	for key, values := range extra {
+		query[key] = values
+	}
+	query.Set("chain", s.chainID)
+	query.Set("hop", strconv.Itoa(s.hop+1))
+	if s.status != http.StatusFound {
+		query.Set("status", strconv.Itoa(s.status))
+	}
+	return query.Encode()
+}
+
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// writeRedirect sends a redirect to location and records it in the access
+// log entry logID
+func writeRedirect(w http.ResponseWriter, r *http.Request, logID uint64, state redirectState, location string) {
+	logger.Update(logID, func(log *AccessLog) {
+		log.Redirect = &RedirectLog{
+			ChainID:  state.chainID,
+			Hop:      state.hop,
+			Status:   state.status,
+			Location: location,
+		}
+	})
+	setSpanAttribute(r.Context(), "debug_httpd.redirect.chain_id", state.chainID)
+
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(state.status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status_code": state.status,
+		"location":    location,
+		"chain_id":    state.chainID,
+		"hop":         state.hop,
+	})
+}
+
+// writeRedirectDestination ends a redirect chain, describing the request
+// that arrived after following it
+func writeRedirectDestination(w http.ResponseWriter, r *http.Request, logID uint64, state redirectState) {
+	logger.Update(logID, func(log *AccessLog) {
+		log.Redirect = &RedirectLog{ChainID: state.chainID, Hop: state.hop}
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chain_id":       state.chainID,
+		"redirects":      state.hop,
+		"method":         r.Method,
+		"url":            r.URL.String(),
+		"host":           r.Host,
+		"content_length": r.ContentLength,
+		"referer":        r.Referer(),
+		"timestamp":      time.Now().Format(time.RFC3339Nano),
+	})
+}
+
+// writeRedirectError sends a 400 response for invalid redirect parameters
+func writeRedirectError(w http.ResponseWriter, err error, example string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   err.Error(),
+		"example": example,
+	})
+}
+
+// parseRedirectCount parses the {n} path segment after prefix
+func parseRedirectCount(r *http.Request, prefix string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil || n < 0 || n > maxRedirects {
+		return 0, fmt.Errorf("number of redirects must be between 0 and %d", maxRedirects)
+	}
+	return n, nil
+}
+
+// redirectHandler handles /redirect/{n} requests by redirecting n times
+// with path-absolute (or, with absolute=true, absolute) Location headers
+func redirectHandler(w http.ResponseWriter, r *http.Request) {
+	logID := logAccess(r)
+
+	n, err := parseRedirectCount(r, "/redirect/")
+	if err != nil {
+		writeRedirectError(w, err, "/redirect/3?status=307")
+		return
+	}
+	state, err := parseRedirectState(r.URL.Query())
+	if err != nil {
+		writeRedirectError(w, err, "/redirect/3?status=307")
+		return
+	}
+	if n == 0 {
+		writeRedirectDestination(w, r, logID, state)
+		return
+	}
+
+	extra := url.Values{}
+	location := fmt.Sprintf("/redirect/%d", n-1)
+	if r.URL.Query().Get("absolute") == "true" {
+		extra.Set("absolute", "true")
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		location = scheme + "://" + r.Host + location
+	}
+	writeRedirect(w, r, logID, state, location+"?"+state.next(extra))
+}
+
+// relativeRedirectHandler handles /relative-redirect/{n} requests by
+// redirecting n times with relative Location headers, which resolve
+// correctly even behind a path-prefix ingress
+func relativeRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	logID := logAccess(r)
+
+	n, err := parseRedirectCount(r, "/relative-redirect/")
+	if err != nil {
+		writeRedirectError(w, err, "/relative-redirect/3")
+		return
+	}
+	state, err := parseRedirectState(r.URL.Query())
+	if err != nil {
+		writeRedirectError(w, err, "/relative-redirect/3")
+		return
+	}
+	if n == 0 {
+		writeRedirectDestination(w, r, logID, state)
+		return
+	}
+
+	writeRedirect(w, r, logID, state, fmt.Sprintf("%d?%s", n-1, state.next(nil)))
+}
+
+// redirectToHandler handles /redirect-to?url=...&status=... requests by
+// redirecting to an arbitrary URL
+func redirectToHandler(w http.ResponseWriter, r *http.Request) {
+	logID := logAccess(r)
+
+	target := r.URL.Query().Get("url")
+	if target == "" {
+		writeRedirectError(w, fmt.Errorf("url parameter is required"), "/redirect-to?url=https://example.com/&status=308")
+		return
+	}
+	state, err := parseRedirectState(r.URL.Query())
+	if err != nil {
+		writeRedirectError(w, err, "/redirect-to?url=https://example.com/&status=308")
+		return
+	}
+
+	writeRedirect(w, r, logID, state, target)
+}
+
+// redirectLoopHandler handles /redirect-loop requests by redirecting back
+// to itself forever, counting the hops in the query string
+func redirectLoopHandler(w http.ResponseWriter, r *http.Request) {
+	logID := logAccess(r)
+
+	state, err := parseRedirectState(r.URL.Query())
+	if err != nil {
+		writeRedirectError(w, err, "/redirect-loop?status=302")
+		return
+	}
+
+	writeRedirect(w, r, logID, state, "/redirect-loop?"+state.next(nil))
+}
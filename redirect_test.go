@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// followRedirects sends req, following redirects, and decodes the
+// final JSON response
+func followRedirects(t *testing.T, req *http.Request) map[string]interface{} {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	return response
+}
+
+func TestRedirectHandler(t *testing.T) {
+	logger = NewAccessLogger(100)
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/redirect/3", nil)
+	response := followRedirects(t, req)
+	if response["redirects"].(float64) != 3 {
+		t.Errorf("unexpected redirect count: %v", response["redirects"])
+	}
+
+	// Every step is logged with the same chain ID
+	chainID := response["chain_id"].(string)
+	var hops []int
+	for _, log := range logger.GetLogs() {
+		if log.Redirect == nil || log.Redirect.ChainID != chainID {
+			t.Errorf("log entry %s missing chain %s: %+v", log.Path, chainID, log.Redirect)
+			continue
+		}
+		hops = append(hops, log.Redirect.Hop)
+	}
+	if len(hops) != 4 || hops[0] != 0 || hops[3] != 3 {
+		t.Errorf("unexpected logged hops: %v", hops)
+	}
+}
+
+func TestRedirectHandler_MethodRewriting(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	tests := []struct {
+		status string
+		method string
+	}{
+		{"303", "GET"},
+		{"307", "POST"},
+		{"308", "POST"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", server.URL+"/redirect/2?status="+tt.status, strings.NewReader("body"))
+		response := followRedirects(t, req)
+		if response["method"] != tt.method {
+			t.Errorf("status %s: got method %v want %v", tt.status, response["method"], tt.method)
+		}
+	}
+}
+
+func TestRedirectHandler_Absolute(t *testing.T) {
+	req, err := http.NewRequest("GET", "/redirect/2?absolute=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "example.com"
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(redirectHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); !strings.HasPrefix(location, "http://example.com/redirect/1?") || !strings.Contains(location, "absolute=true") {
+		t.Errorf("unexpected Location: %v", location)
+	}
+}
+
+func TestRelativeRedirectHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/relative-redirect/2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(relativeRedirectHandler)
+	handler.ServeHTTP(rr, req)
+
+	if location := rr.Header().Get("Location"); !strings.HasPrefix(location, "1?") {
+		t.Errorf("unexpected Location: %v", location)
+	}
+
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+	req, _ = http.NewRequest("GET", server.URL+"/relative-redirect/2", nil)
+	response := followRedirects(t, req)
+	if !strings.HasPrefix(response["url"].(string), "/relative-redirect/0?") {
+		t.Errorf("unexpected final URL: %v", response["url"])
+	}
+}
+
+func TestRedirectToHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/redirect-to?url=https://example.com/path&status=308", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(redirectToHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusPermanentRedirect {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusPermanentRedirect)
+	}
+	if location := rr.Header().Get("Location"); location != "https://example.com/path" {
+		t.Errorf("unexpected Location: %v", location)
+	}
+}
+
+func TestRedirectLoopHandler(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	_, err := http.Get(server.URL + "/redirect-loop")
+	if err == nil || !strings.Contains(err.Error(), "stopped after 10 redirects") {
+		t.Errorf("expected the client to give up, got %v", err)
+	}
+}
+
+func TestRedirectHandlers_InvalidParams(t *testing.T) {
+	tests := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/redirect/abc", redirectHandler},
+		{"/redirect/101", redirectHandler},
+		{"/redirect/2?status=200", redirectHandler},
+		{"/relative-redirect/-1", relativeRedirectHandler},
+		{"/redirect-to", redirectToHandler},
+		{"/redirect-to?url=/&status=304", redirectToHandler},
+		{"/redirect-loop?hop=abc", redirectLoopHandler},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				tt.path, status, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits for /sse parameters
+const (
+	maxSSECount = 100000
+	maxSSESize  = 1 << 20
+)
+
+// sseHandler handles /sse requests by streaming Server-Sent Events at a
+// fixed interval
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+	query := r.URL.Query()
+
+	interval := time.Second
+	if intervalStr := query.Get("interval"); intervalStr != "" {
+		var err error
+		interval, err = time.ParseDuration(intervalStr)
+		if err != nil || interval < time.Millisecond || interval > time.Hour {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "interval must be a duration between 1ms and 1h",
+				"example": "/sse?interval=1s&count=100",
+			})
+			return
+		}
+	}
+
+	count, err := parseIntParam(query.Get("count"), 10, 0, maxSSECount)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("count must be between 0 and %d (0 streams until the client disconnects)", maxSSECount),
+		})
+		return
+	}
+
+	size, err := parseIntParam(query.Get("size"), 0, 0, maxSSESize)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("size must be between 0 and %d", maxSSESize),
+		})
+		return
+	}
+
+	retry, err := parseIntParam(query.Get("retry"), 0, 0, 24*60*60*1000)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "retry must be a number of milliseconds",
+		})
+		return
+	}
+
+	// Resume after the last event the client saw
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+	start := 1
+	if lastEventID != "" {
+		id, err := strconv.Atoi(lastEventID)
+		if err != nil || id < 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Last-Event-ID must be a non-negative integer",
+			})
+			return
+		}
+		start = id + 1
+	}
+
+	// A line break would end the field and let the value inject other
+	// fields or events
+	withIDs := query.Get("id") != "false"
+	event := query.Get("event")
+	if strings.ContainsAny(event, "\r\n") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "event must not contain CR or LF",
+			"example": "/sse?event=update",
+		})
+		return
+	}
+	padding := strings.Repeat("x", size)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if query.Get("no_buffering") == "true" {
+		w.Header().Set("X-Accel-Buffering", "no")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", retry)
+	}
+	rc.Flush()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for id := start; count == 0 || id <= count; id++ {
+		if id > start {
+			select {
+			case <-ticker.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":        id,
+			"timestamp": time.Now().Format(time.RFC3339Nano),
+			"padding":   padding,
+		})
+
+		var b strings.Builder
+		if withIDs {
+			fmt.Fprintf(&b, "id: %d\n", id)
+		}
+		if event != "" {
+			fmt.Fprintf(&b, "event: %s\n", event)
+		}
+		writeSSEData(&b, string(data))
+		b.WriteString("\n")
+
+		if _, err := w.Write([]byte(b.String())); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeSSEData writes data as data: fields, one per line, so that line
+// breaks in data survive instead of ending the field
+func writeSSEData(b *strings.Builder, data string) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(b, "data: %s\n", line)
+	}
+}
+
+// parseIntParam parses an optional integer query parameter within [min, max]
+func parseIntParam(s string, def, min, max int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("%d is out of range [%d, %d]", n, min, max)
+	}
+	return n, nil
+}
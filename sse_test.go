@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// readSSE reads the events and the retry field of an event stream
+func readSSE(t *testing.T, resp *http.Response) ([]testSSEEvent, string) {
+	var events []testSSEEvent
+	var retry string
+	var current testSSEEvent
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if current.Data != "" {
+				events = append(events, current)
+			}
+			current = testSSEEvent{}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			current.ID = value
+		case "event":
+			current.Event = value
+		case "data":
+			current.Data = value
+		case "retry":
+			retry = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events, retry
+}
+
+func TestSSEHandler(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/sse?interval=50ms&count=3&size=16&retry=1500&event=tick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected Content-Type: %v", ct)
+	}
+
+	events, retry := readSSE(t, resp)
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("stream finished too early: %v", elapsed)
+	}
+	if retry != "1500" {
+		t.Errorf("unexpected retry field: %q", retry)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	for i, event := range events {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+			t.Fatalf("event data is not JSON: %v", err)
+		}
+		if want := string(rune('1' + i)); event.ID != want {
+			t.Errorf("unexpected event id: got %v want %v", event.ID, want)
+		}
+		if event.Event != "tick" {
+			t.Errorf("unexpected event name: %v", event.Event)
+		}
+		if padding, _ := data["padding"].(string); len(padding) != 16 {
+			t.Errorf("unexpected padding length: %d", len(padding))
+		}
+	}
+}
+
+func TestSSEHandler_LastEventID(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/sse?interval=10ms&count=5", nil)
+	req.Header.Set("Last-Event-ID", "3")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	events, _ := readSSE(t, resp)
+	if len(events) != 2 || events[0].ID != "4" || events[1].ID != "5" {
+		t.Errorf("unexpected resumed events: %+v", events)
+	}
+}
+
+func TestSSEHandler_InvalidParams(t *testing.T) {
+	tests := []string{
+		"/sse?interval=abc",
+		"/sse?interval=0s",
+		"/sse?count=-1",
+		"/sse?size=abc",
+		"/sse?retry=-5",
+		"/sse?last_event_id=abc",
+		"/sse?event=a%0Adata:%20injected",
+		"/sse?event=a%0D%0Aid:%201",
+	}
+
+	for _, path := range tests {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(sseHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestWriteSSEData(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"{}", "data: {}\n"},
+		{"a\nb", "data: a\ndata: b\n"},
+		{"a\r\nb\rc", "data: a\ndata: b\ndata: c\n"},
+		{"a\n", "data: a\ndata: \n"},
+		{"", "data: \n"},
+	}
+
+	for _, tt := range tests {
+		var b strings.Builder
+		writeSSEData(&b, tt.data)
+		if got := b.String(); got != tt.want {
+			t.Errorf("%q: got %q want %q", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits for /stream and /drip parameters
+const (
+	maxStreamLines = 100000
+	maxDripBytes   = 10 << 20
+	maxDripChunks  = 100000
+	maxStreamWait  = time.Hour
+)
+
+// Trailers sent by /stream and /drip when trailers=true
+const streamTrailers = "X-Body-Bytes, X-Body-Sha256, X-Duration"
+
+// streamBody writes a response body in flushed chunks, keeping track of the
+// size and checksum for the trailers
+type streamBody struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	trailers bool
+	hash     hash.Hash
+	bytes    int64
+	start    time.Time
+}
+
+// newStreamBody prepares w for a chunked streaming response. It must be
+// called before the status code is written.
+func newStreamBody(w http.ResponseWriter, r *http.Request) *streamBody {
+	s := &streamBody{
+		w:        w,
+		rc:       http.NewResponseController(w),
+		trailers: r.URL.Query().Get("trailers") == "true",
+		hash:     sha256.New(),
+		start:    time.Now(),
+	}
+	if s.trailers {
+		w.Header().Set("Trailer", streamTrailers)
+	}
+	return s
+}
+
+// write sends p as its own chunk
+func (s *streamBody) write(p []byte) error {
+	if _, err := s.w.Write(p); err != nil {
+		return err
+	}
+	s.hash.Write(p)
+	s.bytes += int64(len(p))
+	return s.rc.Flush()
+}
+
+// finish sets the trailers, if they were requested
+func (s *streamBody) finish() {
+	if !s.trailers {
+		return
+	}
+	s.w.Header().Set("X-Body-Bytes", strconv.FormatInt(s.bytes, 10))
+	s.w.Header().Set("X-Body-Sha256", hex.EncodeToString(s.hash.Sum(nil)))
+	s.w.Header().Set("X-Duration", time.Since(s.start).String())
+}
+
+// waitOrDone waits for d, returning false if ctx is cancelled first
+func waitOrDone(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// parseStreamDuration parses an optional duration query parameter within
+// [0, maxStreamWait]
+func parseStreamDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 || d > maxStreamWait {
+		return 0, fmt.Errorf("%v is out of range [0s, %v]", d, maxStreamWait)
+	}
+	return d, nil
+}
+
+// streamHandler handles /stream/{n} requests by writing n JSON lines, each
+// flushed as a separate chunk
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+
+	n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/stream/"))
+	if err != nil || n < 1 || n > maxStreamLines {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   fmt.Sprintf("number of lines must be between 1 and %d", maxStreamLines),
+			"example": "/stream/10?interval=500ms",
+		})
+		return
+	}
+
+	interval, err := parseStreamDuration(r.URL.Query().Get("interval"), 0)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("invalid interval: %v", err),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	body := newStreamBody(w, r)
+	w.WriteHeader(http.StatusOK)
+
+	for i := 1; i <= n; i++ {
+		if i > 1 && !waitOrDone(r.Context(), interval) {
+			return
+		}
+		line, _ := json.Marshal(map[string]interface{}{
+			"id":        i,
+			"timestamp": time.Now().Format(time.RFC3339Nano),
+			"elapsed":   time.Since(body.start).String(),
+		})
+		if err := body.write(append(line, '\n')); err != nil {
+			return
+		}
+	}
+	body.finish()
+}
+
+// dripHandler handles /drip requests by writing bytes in evenly spaced
+// chunks over a duration
+func dripHandler(w http.ResponseWriter, r *http.Request) {
+	logAccess(r)
+	query := r.URL.Query()
+
+	size, err := parseIntParam(query.Get("bytes"), 10, 0, maxDripBytes)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   fmt.Sprintf("bytes must be between 0 and %d", maxDripBytes),
+			"example": "/drip?bytes=100&duration=10s&chunks=10",
+		})
+		return
+	}
+
+	chunks, err := parseIntParam(query.Get("chunks"), min(size, 10), 1, maxDripChunks)
+	if err != nil || (size > 0 && chunks > size) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("chunks must be between 1 and min(bytes, %d)", maxDripChunks),
+		})
+		return
+	}
+	if size == 0 {
+		chunks = 0
+	}
+
+	duration, err := parseStreamDuration(query.Get("duration"), 2*time.Second)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("invalid duration: %v", err),
+		})
+		return
+	}
+
+	delay, err := parseStreamDuration(query.Get("delay"), 0)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("invalid delay: %v", err),
+		})
+		return
+	}
+
+	// Delay before sending the status line and headers
+	if !waitOrDone(r.Context(), delay) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	body := newStreamBody(w, r)
+	w.WriteHeader(http.StatusOK)
+
+	var pause time.Duration
+	if chunks > 0 {
+		pause = duration / time.Duration(chunks)
+	}
+	for i := 0; i < chunks; i++ {
+		// Spread the remainder over the first chunks
+		chunkSize := size / chunks
+		if i < size%chunks {
+			chunkSize++
+		}
+		if err := body.write([]byte(strings.Repeat("*", chunkSize))); err != nil {
+			return
+		}
+		if !waitOrDone(r.Context(), pause) {
+			return
+		}
+	}
+	body.finish()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamHandler(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream/3?interval=30ms&trailers=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("expected a chunked response, got %v", resp.TransferEncoding)
+	}
+
+	// Each line arrives separately
+	reader := bufio.NewReader(resp.Body)
+	var arrivals []time.Time
+	var body []byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		arrivals = append(arrivals, time.Now())
+		body = append(body, line...)
+
+		var data map[string]interface{}
+		if err := json.Unmarshal(line, &data); err != nil {
+			t.Fatalf("line is not JSON: %v", err)
+		}
+		if id := data["id"].(float64); int(id) != len(arrivals) {
+			t.Errorf("unexpected line id: got %v want %v", id, len(arrivals))
+		}
+	}
+	if len(arrivals) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(arrivals))
+	}
+	if gap := arrivals[2].Sub(arrivals[0]); gap < 50*time.Millisecond {
+		t.Errorf("lines were not spread over time: %v", gap)
+	}
+
+	sum := sha256.Sum256(body)
+	if got := resp.Trailer.Get("X-Body-Sha256"); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected X-Body-Sha256 trailer: %v", got)
+	}
+	if got := resp.Trailer.Get("X-Body-Bytes"); got != strconv.Itoa(len(body)) {
+		t.Errorf("unexpected X-Body-Bytes trailer: %v", got)
+	}
+}
+
+func TestDripHandler(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/drip?bytes=10&chunks=3&duration=150ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != strings.Repeat("*", 10) {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("drip finished too early: %v", elapsed)
+	}
+	if len(resp.Trailer) != 0 {
+		t.Errorf("unexpected trailers: %v", resp.Trailer)
+	}
+}
+
+func TestDripHandler_InvalidParams(t *testing.T) {
+	tests := []string{
+		"/drip?bytes=-1",
+		"/drip?bytes=abc",
+		"/drip?bytes=5&chunks=10",
+		"/drip?chunks=0",
+		"/drip?duration=abc",
+		"/drip?delay=-1s",
+	}
+
+	for _, path := range tests {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(dripHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestStreamHandler_InvalidCount(t *testing.T) {
+	for _, path := range []string{"/stream/", "/stream/abc", "/stream/0", "/stream/3?interval=abc"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(streamHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, status, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// throttleConfig limits the bandwidth of responses. It is configured by
+// the -throttle and -throttle-routes flags.
+var throttleConfig = &ThrottleConfig{}
+
+// ThrottleConfig holds the default response rate and per-route overrides,
+// in bytes per second. A rate of 0 is unlimited.
+type ThrottleConfig struct {
+	rate   int64
+	routes []throttleRoute
+}
+
+type throttleRoute struct {
+	prefix string
+	rate   int64
+}
+
+// bitRateUnits lists lower-cased bit rate suffixes, longest first, with
+// their multipliers in bits per second
+var bitRateUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"kbps", 1000},
+	{"mbps", 1000 * 1000},
+	{"gbps", 1000 * 1000 * 1000},
+	{"bps", 1},
+}
+
+// parseBandwidth parses a rate such as 56kbps, 10Mbps, 64KiB/s or 1MB into
+// bytes per second. "0" and "off" mean unlimited.
+func parseBandwidth(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	if lower == "0" || lower == "off" {
+		return 0, nil
+	}
+
+	for _, unit := range bitRateUnits {
+		number, ok := strings.CutSuffix(lower, unit.suffix)
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil || value <= 0 {
+			return 0, fmt.Errorf("invalid bandwidth %q", s)
+		}
+		return max(1, int64(value*unit.multiplier/8)), nil
+	}
+
+	rate, err := parseByteSize(strings.TrimSuffix(s, "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q (e.g. 56kbps, 10Mbps, 64KiB/s)", s)
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	return rate, nil
+}
+
+// ParseThrottleConfig parses the default rate and a comma separated list of
+// prefix=rate route overrides (e.g. "/bytes/=10Mbps,/logs=off")
+func ParseThrottleConfig(rate, routes string) (*ThrottleConfig, error) {
+	tc := &ThrottleConfig{}
+	if rate != "" {
+		var err error
+		tc.rate, err = parseBandwidth(rate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range strings.Split(routes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, rateStr, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid throttle route %q (expected /path=rate)", entry)
+		}
+		routeRate, err := parseBandwidth(rateStr)
+		if err != nil {
+			return nil, err
+		}
+		tc.routes = append(tc.routes, throttleRoute{prefix: prefix, rate: routeRate})
+	}
+
+	// Longest prefix first
+	sort.SliceStable(tc.routes, func(i, j int) bool {
+		return len(tc.routes[i].prefix) > len(tc.routes[j].prefix)
+	})
+	return tc, nil
+}
+
+// IsEmpty reports whether no throttling is configured
+func (tc *ThrottleConfig) IsEmpty() bool {
+	return tc.rate == 0 && len(tc.routes) == 0
+}
+
+// RateFor returns the rate for path, in bytes per second
+func (tc *ThrottleConfig) RateFor(path string) int64 {
+	for _, route := range tc.routes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.rate
+		}
+	}
+	return tc.rate
+}
+
+// String describes the configuration for the startup log
+func (tc *ThrottleConfig) String() string {
+	parts := []string{}
+	if tc.rate > 0 {
+		parts = append(parts, formatByteSize(tc.rate)+"/s")
+	}
+	for _, route := range tc.routes {
+		rate := "unlimited"
+		if route.rate > 0 {
+			rate = formatByteSize(route.rate) + "/s"
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", route.prefix, rate))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// throttledWriter limits writes to the underlying ResponseWriter to rate
+// bytes per second
+type throttledWriter struct {
+	http.ResponseWriter
+	pace pacer
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := tw.pace.chunk(len(p) - written)
+		n, err := tw.ResponseWriter.Write(p[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		tw.Flush()
+		if !tw.pace.wait(n) {
+			return written, tw.pace.ctx.Err()
+		}
+	}
+	return written, nil
+}
+
+func (tw *throttledWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *throttledWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := tw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijacking")
+}
+
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// withThrottle limits the bandwidth of responses according to
+// throttleConfig. A request can choose its own rate with the throttle query
+// parameter or the X-Throttle header.
+func withThrottle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rate := throttleConfig.RateFor(r.URL.Path)
+
+		override := r.URL.Query().Get("throttle")
+		if override == "" {
+			override = r.Header.Get("X-Throttle")
+		}
+		if override != "" {
+			var err error
+			rate, err = parseBandwidth(override)
+			if err != nil {
+				logAccess(r)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error":   err.Error(),
+					"example": "?throttle=56kbps",
+				})
+				return
+			}
+		}
+
+		if rate == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-Throttle-Rate", strconv.FormatInt(rate, 10))
+		next.ServeHTTP(&throttledWriter{ResponseWriter: w, pace: pacer{ctx: r.Context(), rate: rate}}, r)
+	})
+}
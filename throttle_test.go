@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withThrottleConfig replaces throttleConfig for the duration of a test
+func withThrottleConfig(t *testing.T, rate, routes string) {
+	tc, err := ParseThrottleConfig(rate, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := throttleConfig
+	throttleConfig = tc
+	t.Cleanup(func() { throttleConfig = original })
+}
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"56kbps", 7000},
+		{"10Mbps", 1250000},
+		{"1Gbps", 125000000},
+		{"800bps", 100},
+		{"1bps", 1},
+		{"64KiB/s", 65536},
+		{"1MB", 1000000},
+		{"off", 0},
+		{"0", 0},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBandwidth(tt.input)
+		if err != nil {
+			t.Errorf("parseBandwidth(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBandwidth(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "fast", "-5kbps", "kbps", "10XB/s"} {
+		if _, err := parseBandwidth(input); err == nil {
+			t.Errorf("parseBandwidth(%q) expected error", input)
+		}
+	}
+}
+
+func TestThrottleConfig_RateFor(t *testing.T) {
+	tc, err := ParseThrottleConfig("56kbps", "/bytes/=10Mbps, /bytes/big=1Mbps, /logs=off")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want int64
+	}{
+		{"/", 7000},
+		{"/bytes/10", 1250000},
+		{"/bytes/big", 125000},
+		{"/logs", 0},
+	}
+	for _, tt := range tests {
+		if got := tc.RateFor(tt.path); got != tt.want {
+			t.Errorf("RateFor(%q) = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := ParseThrottleConfig("", "bytes=1Mbps"); err == nil {
+		t.Error("expected error for route without leading slash")
+	}
+	if _, err := ParseThrottleConfig("slow", ""); err == nil {
+		t.Error("expected error for invalid rate")
+	}
+}
+
+func TestWithThrottle(t *testing.T) {
+	withThrottleConfig(t, "", "/bytes/=20KB/s")
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	// Route limit applies to /bytes
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/bytes/5000?mode=zero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("response was not throttled: %v", elapsed)
+	}
+	if len(body) != 5000 {
+		t.Errorf("unexpected body length: %d", len(body))
+	}
+	if rate := resp.Header.Get("X-Throttle-Rate"); rate != "20000" {
+		t.Errorf("unexpected X-Throttle-Rate: %v", rate)
+	}
+
+	// Other routes are not throttled
+	resp, err = http.Get(server.URL + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if rate := resp.Header.Get("X-Throttle-Rate"); rate != "" {
+		t.Errorf("unexpected X-Throttle-Rate on /ping: %v", rate)
+	}
+
+	// Per-request override with the header
+	req, _ := http.NewRequest("GET", server.URL+"/bytes/5000?mode=zero", nil)
+	req.Header.Set("X-Throttle", "off")
+	start = time.Now()
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("override did not disable throttling: %v", elapsed)
+	}
+}
+
+func TestWithThrottle_QueryOverride(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/logs?throttle=80kbps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Throttle-Rate") != "10000" {
+		t.Errorf("unexpected X-Throttle-Rate: %v", resp.Header.Get("X-Throttle-Rate"))
+	}
+	if want := time.Duration(len(body)) * time.Second / 10000; time.Since(start) < want*9/10 {
+		t.Errorf("response of %d bytes arrived faster than the limit allows: %v", len(body), time.Since(start))
+	}
+
+	resp, err = http.Get(server.URL + "/?throttle=fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusBadRequest)
+	}
+}
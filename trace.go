@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceContext holds the tracing identifiers of a request. SpanID is the
+// span of this server; ParentSpanID is the span of the caller, if any.
+type TraceContext struct {
+	TraceID      string   `json:"trace_id"`
+	SpanID       string   `json:"span_id"`
+	ParentSpanID string   `json:"parent_span_id,omitempty"`
+	Sampled      bool     `json:"sampled"`
+	TraceState   string   `json:"tracestate,omitempty"`
+	RequestID    string   `json:"request_id"`
+	Source       string   `json:"source"`
+	Formats      []string `json:"formats"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+type traceContextKey struct{}
+
+// withTraceContext parses or generates the trace context of each request,
+// echoes it in the response headers and makes it available to handlers
+func withTraceContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc := parseTraceContext(r.Header)
+		setTraceResponseHeaders(w.Header(), tc)
+
+		ctx := context.WithValue(r.Context(), traceContextKey{}, tc)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// traceFromContext returns the trace context stored by withTraceContext
+func traceFromContext(ctx context.Context) *TraceContext {
+	tc, _ := ctx.Value(traceContextKey{}).(*TraceContext)
+	return tc
+}
+
+// traceFromRequest returns the trace context of r, parsing the headers if
+// the request didn't go through withTraceContext (e.g., in tests)
+func traceFromRequest(r *http.Request) *TraceContext {
+	if tc := traceFromContext(r.Context()); tc != nil {
+		return tc
+	}
+	return parseTraceContext(r.Header)
+}
+
+// parseTraceContext reads W3C Trace Context, B3 and X-Request-Id headers.
+// traceparent takes precedence over b3, which takes precedence over the
+// X-B3-* headers. Missing IDs are generated, keeping the sampling decision
+// of a sampling-only b3 header.
+func parseTraceContext(h http.Header) *TraceContext {
+	tc := &TraceContext{
+		Sampled: true,
+		Formats: []string{},
+	}
+
+	if v := h.Get("traceparent"); v != "" {
+		tc.Formats = append(tc.Formats, "traceparent")
+		if traceID, spanID, sampled, err := parseTraceparent(v); err != nil {
+			tc.Errors = append(tc.Errors, fmt.Sprintf("traceparent: %v", err))
+		} else {
+			tc.TraceID, tc.ParentSpanID, tc.Sampled = traceID, spanID, sampled
+			tc.Source = "traceparent"
+			tc.TraceState = h.Get("tracestate")
+		}
+	}
+
+	if v := h.Get("b3"); v != "" {
+		tc.Formats = append(tc.Formats, "b3")
+		if traceID, spanID, sampled, err := parseB3Single(v); err != nil {
+			tc.Errors = append(tc.Errors, fmt.Sprintf("b3: %v", err))
+		} else if tc.Source == "" {
+			if sampled != nil {
+				tc.Sampled = *sampled
+			}
+			// A sampling-only b3 header ("b3: 0") carries no IDs to adopt
+			if traceID != "" {
+				tc.TraceID, tc.ParentSpanID = traceID, spanID
+				tc.Source = "b3"
+			}
+		}
+	}
+
+	if v := h.Get("X-B3-TraceId"); v != "" {
+		tc.Formats = append(tc.Formats, "x-b3")
+		traceID, spanID, err := parseB3IDs(v, h.Get("X-B3-SpanId"))
+		if err != nil {
+			tc.Errors = append(tc.Errors, fmt.Sprintf("x-b3: %v", err))
+		} else if tc.Source == "" {
+			tc.TraceID, tc.ParentSpanID = traceID, spanID
+			switch {
+			case h.Get("X-B3-Flags") == "1":
+				tc.Sampled = true
+			case h.Get("X-B3-Sampled") != "":
+				tc.Sampled = h.Get("X-B3-Sampled") == "1" || h.Get("X-B3-Sampled") == "true"
+			}
+			tc.Source = "x-b3"
+		}
+	}
+
+	if tc.Source == "" {
+		tc.TraceID = randomHex(16)
+		tc.Source = "generated"
+	}
+	tc.SpanID = randomHex(8)
+
+	tc.RequestID = h.Get("X-Request-Id")
+	if tc.RequestID == "" {
+		tc.RequestID = newUUID()
+	} else {
+		tc.Formats = append(tc.Formats, "x-request-id")
+	}
+
+	return tc
+}
+
+// parseTraceparent parses a W3C traceparent header
+func parseTraceparent(v string) (traceID, spanID string, sampled bool, err error) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 {
+		return "", "", false, fmt.Errorf("expected 4 fields, got %d", len(parts))
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	if !isHex(version, 2) || version == "ff" {
+		return "", "", false, fmt.Errorf("invalid version %q", version)
+	}
+	if version == "00" && len(parts) != 4 {
+		return "", "", false, fmt.Errorf("version 00 must have 4 fields")
+	}
+	if !isHex(traceID, 32) || strings.Trim(traceID, "0") == "" {
+		return "", "", false, fmt.Errorf("invalid trace-id %q", traceID)
+	}
+	if !isHex(spanID, 16) || strings.Trim(spanID, "0") == "" {
+		return "", "", false, fmt.Errorf("invalid parent-id %q", spanID)
+	}
+	if !isHex(flags, 2) {
+		return "", "", false, fmt.Errorf("invalid trace-flags %q", flags)
+	}
+
+	flagBits, _ := hex.DecodeString(flags)
+	return traceID, spanID, flagBits[0]&0x01 != 0, nil
+}
+
+// parseB3Single parses a single b3 header:
+// {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}, or just a sampling state
+func parseB3Single(v string) (traceID, spanID string, sampled *bool, err error) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+
+	if len(parts) == 1 {
+		s, err := parseB3Sampling(parts[0])
+		return "", "", s, err
+	}
+
+	traceID, spanID, err = parseB3IDs(parts[0], parts[1])
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(parts) >= 3 {
+		if sampled, err = parseB3Sampling(parts[2]); err != nil {
+			return "", "", nil, err
+		}
+	}
+	return traceID, spanID, sampled, nil
+}
+
+// parseB3Sampling parses a b3 sampling state: 0, 1 or d (debug)
+func parseB3Sampling(v string) (*bool, error) {
+	var sampled bool
+	switch v {
+	case "1", "d":
+		sampled = true
+	case "0":
+		sampled = false
+	default:
+		return nil, fmt.Errorf("invalid sampling state %q", v)
+	}
+	return &sampled, nil
+}
+
+// parseB3IDs validates B3 trace and span IDs. 64-bit trace IDs are padded
+// to 128 bits so they can be used in traceparent.
+func parseB3IDs(traceID, spanID string) (string, string, error) {
+	traceID = strings.ToLower(traceID)
+	spanID = strings.ToLower(spanID)
+
+	if isHex(traceID, 16) {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	if !isHex(traceID, 32) || strings.Trim(traceID, "0") == "" {
+		return "", "", fmt.Errorf("invalid trace id %q", traceID)
+	}
+	if !isHex(spanID, 16) || strings.Trim(spanID, "0") == "" {
+		return "", "", fmt.Errorf("invalid span id %q", spanID)
+	}
+	return traceID, spanID, nil
+}
+
+// setTraceResponseHeaders echoes the trace context in response headers
+func setTraceResponseHeaders(h http.Header, tc *TraceContext) {
+	h.Set("traceparent", tc.Traceparent())
+	if tc.TraceState != "" {
+		h.Set("tracestate", tc.TraceState)
+	}
+	h.Set("X-Request-Id", tc.RequestID)
+
+	switch tc.Source {
+	case "b3":
+		h.Set("b3", fmt.Sprintf("%s-%s-%s", tc.TraceID, tc.SpanID, tc.b3Sampled()))
+	case "x-b3":
+		h.Set("X-B3-TraceId", tc.TraceID)
+		h.Set("X-B3-SpanId", tc.SpanID)
+		h.Set("X-B3-Sampled", tc.b3Sampled())
+	}
+}
+
+// injectTraceHeaders adds the trace context of ctx to the headers of an
+// outbound request, with this server's span as the parent
+func injectTraceHeaders(ctx context.Context, h http.Header) {
+	tc := traceFromContext(ctx)
+	if tc == nil {
+		return
+	}
+
+	h.Set("traceparent", tc.Traceparent())
+	if tc.TraceState != "" {
+		h.Set("tracestate", tc.TraceState)
+	}
+	h.Set("X-B3-TraceId", tc.TraceID)
+	h.Set("X-B3-SpanId", tc.SpanID)
+	h.Set("X-B3-Sampled", tc.b3Sampled())
+	h.Set("X-Request-Id", tc.RequestID)
+}
+
+// Traceparent returns the W3C traceparent header for this server's span
+func (tc *TraceContext) Traceparent() string {
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", tc.TraceID, tc.SpanID, flags)
+}
+
+func (tc *TraceContext) b3Sampled() string {
+	if tc.Sampled {
+		return "1"
+	}
+	return "0"
+}
+
+// isHex reports whether s is n lowercase hex digits
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes as a hex string
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
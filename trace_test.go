@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		source       string
+		traceID      string
+		parentSpanID string
+		sampled      bool
+		hasErrors    bool
+	}{
+		{
+			name:         "traceparent",
+			headers:      map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "tracestate": "congo=t61rcWkgMzE"},
+			source:       "traceparent",
+			traceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+			parentSpanID: "00f067aa0ba902b7",
+			sampled:      true,
+		},
+		{
+			name:         "traceparent not sampled",
+			headers:      map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+			source:       "traceparent",
+			traceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+			parentSpanID: "00f067aa0ba902b7",
+			sampled:      false,
+		},
+		{
+			name:         "b3 single",
+			headers:      map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-0-05e3ac9a4f6e3b90"},
+			source:       "b3",
+			traceID:      "80f198ee56343ba864fe8b2a57d3eff7",
+			parentSpanID: "e457b5a2e4d86bd1",
+			sampled:      false,
+		},
+		{
+			name:         "b3 multi with 64-bit trace id",
+			headers:      map[string]string{"X-B3-TraceId": "a3ce929d0e0e4736", "X-B3-SpanId": "00f067aa0ba902b7", "X-B3-Sampled": "1"},
+			source:       "x-b3",
+			traceID:      "0000000000000000a3ce929d0e0e4736",
+			parentSpanID: "00f067aa0ba902b7",
+			sampled:      true,
+		},
+		{
+			name:    "b3 sampling only",
+			headers: map[string]string{"b3": "0"},
+			source:  "generated",
+			sampled: false,
+		},
+		{
+			name:    "b3 debug only",
+			headers: map[string]string{"b3": "d"},
+			source:  "generated",
+			sampled: true,
+		},
+		{
+			name:    "none",
+			headers: map[string]string{},
+			source:  "generated",
+			sampled: true,
+		},
+		{
+			name:      "invalid traceparent",
+			headers:   map[string]string{"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+			source:    "generated",
+			sampled:   true,
+			hasErrors: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+
+			tc := parseTraceContext(h)
+			if tc.Source != tt.source {
+				t.Errorf("unexpected source: got %v want %v", tc.Source, tt.source)
+			}
+			if tt.traceID != "" && tc.TraceID != tt.traceID {
+				t.Errorf("unexpected trace id: got %v want %v", tc.TraceID, tt.traceID)
+			}
+			if !isHex(tc.TraceID, 32) {
+				t.Errorf("invalid trace id: %v", tc.TraceID)
+			}
+			if !isHex(tc.SpanID, 16) || tc.SpanID == tc.ParentSpanID {
+				t.Errorf("invalid span id: %v", tc.SpanID)
+			}
+			if tc.ParentSpanID != tt.parentSpanID {
+				t.Errorf("unexpected parent span id: got %v want %v", tc.ParentSpanID, tt.parentSpanID)
+			}
+			if tc.Sampled != tt.sampled {
+				t.Errorf("unexpected sampled flag: got %v want %v", tc.Sampled, tt.sampled)
+			}
+			if (len(tc.Errors) > 0) != tt.hasErrors {
+				t.Errorf("unexpected errors: %v", tc.Errors)
+			}
+			if tc.RequestID == "" {
+				t.Error("request id should be generated")
+			}
+		})
+	}
+}
+
+func TestWithTraceContext(t *testing.T) {
+	logger = NewAccessLogger(100)
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("X-Request-Id", "req-123")
+
+	rr := httptest.NewRecorder()
+	newHandler().ServeHTTP(rr, req)
+
+	traceparent := rr.Header().Get("traceparent")
+	if !strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || strings.Contains(traceparent, "00f067aa0ba902b7") {
+		t.Errorf("unexpected traceparent response header: %v", traceparent)
+	}
+	if id := rr.Header().Get("X-Request-Id"); id != "req-123" {
+		t.Errorf("unexpected X-Request-Id response header: %v", id)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	trace, ok := response["trace"].(map[string]interface{})
+	if !ok {
+		t.Fatal("response missing trace field")
+	}
+	if trace["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || trace["sampled"] != true {
+		t.Errorf("unexpected trace: %v", trace)
+	}
+
+	logs := logger.GetLogs()
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	if logs[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || logs[0].ParentSpanID != "00f067aa0ba902b7" || logs[0].RequestID != "req-123" {
+		t.Errorf("unexpected trace ids in log: %+v", logs[0])
+	}
+	if logs[0].SpanID != trace["span_id"] {
+		t.Errorf("log span id %v doesn't match response span id %v", logs[0].SpanID, trace["span_id"])
+	}
+}
+
+func TestWithTraceContext_B3SamplingOnly(t *testing.T) {
+	logger = NewAccessLogger(100)
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	req.Header.Set("b3", "0")
+	rr := httptest.NewRecorder()
+	newHandler().ServeHTTP(rr, req)
+
+	traceparent := rr.Header().Get("traceparent")
+	if traceID, _, sampled, err := parseTraceparent(traceparent); err != nil || sampled || !isHex(traceID, 32) {
+		t.Errorf("unexpected traceparent %q: %v", traceparent, err)
+	}
+	if b3 := rr.Header().Get("b3"); b3 != "" {
+		t.Errorf("unexpected b3 echo: %q", b3)
+	}
+	if logs := logger.GetLogs(); !isHex(logs[0].TraceID, 32) {
+		t.Errorf("unexpected trace id in log: %q", logs[0].TraceID)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	first := httptest.NewServer(newHandler())
+	defer first.Close()
+	second := httptest.NewServer(newHandler())
+	defer second.Close()
+
+	resp, err := http.Get(first.URL + "/chain?hops=" + second.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+
+	// The second hop is called with the first hop's span as its parent
+	traceparent := resp.Header.Get("traceparent")
+	headers := response["downstream"].(map[string]interface{})["received_headers"].(map[string]interface{})
+	received, _ := headers["Traceparent"].([]interface{})
+	if len(received) != 1 || received[0] != traceparent {
+		t.Errorf("downstream received traceparent %v, want %v", received, traceparent)
+	}
+
+	requestID := resp.Header.Get("X-Request-Id")
+	if ids, _ := headers["X-Request-Id"].([]interface{}); len(ids) != 1 || ids[0] != requestID {
+		t.Errorf("downstream received X-Request-Id %v, want %v", ids, requestID)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// countingReader hashes and counts everything read through it
+type countingReader struct {
+	r    io.Reader
+	hash hash.Hash
+	n    int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.hash.Write(p[:n])
+	cr.n += int64(n)
+	return n, err
+}
+
+// rateReader limits reads from r to rate bytes per second
+type rateReader struct {
+	r    io.Reader
+	pace pacer
+}
+
+func (rr *rateReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p[:rr.pace.chunk(len(p))])
+	if !rr.pace.wait(n) {
+		return n, rr.pace.ctx.Err()
+	}
+	return n, err
+}
+
+// readMultipartParts reads every part of mr, returning the size and hash of
+// each one
+func readMultipartParts(mr *multipart.Reader) ([]map[string]interface{}, error) {
+	parts := []map[string]interface{}{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+
+		h := sha256.New()
+		n, err := io.Copy(h, part)
+		parts = append(parts, map[string]interface{}{
+			"name":         part.FormName(),
+			"filename":     part.FileName(),
+			"content_type": part.Header.Get("Content-Type"),
+			"bytes":        n,
+			"sha256":       hex.EncodeToString(h.Sum(nil)),
+		})
+		if err != nil {
+			return parts, err
+		}
+	}
+}
+
+// uploadHandler handles /upload requests by reading the request body,
+// optionally slowly, and reporting its size, hash and throughput
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	logID := logAccess(r)
+	query := r.URL.Query()
+
+	var rate int64
+	if rateStr := query.Get("rate"); rateStr != "" {
+		var err error
+		rate, err = parseByteSize(strings.TrimSuffix(rateStr, "/s"))
+		if err != nil || rate <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "rate must be a positive size per second",
+				"example": "/upload?rate=1MiB",
+			})
+			return
+		}
+	}
+
+	var limit int64
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = parseByteSize(limitStr)
+		if err != nil || limit <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "limit must be a positive size",
+				"example": "/upload?limit=10MiB",
+			})
+			return
+		}
+	}
+
+	delay, err := parseStreamDuration(query.Get("delay"), 0)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("invalid delay: %v", err),
+		})
+		return
+	}
+
+	// Wait before reading anything, including sending 100 Continue
+	if !waitOrDone(r.Context(), delay) {
+		return
+	}
+
+	start := time.Now()
+	var body io.Reader = r.Body
+	if limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	if rate > 0 {
+		body = &rateReader{r: body, pace: pacer{ctx: r.Context(), rate: rate, start: start}}
+	}
+	counter := &countingReader{r: body, hash: sha256.New()}
+
+	var parts []map[string]interface{}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		parts, err = readMultipartParts(multipart.NewReader(counter, params["boundary"]))
+		if err == nil {
+			// Count the epilogue too
+			_, err = io.Copy(io.Discard, counter)
+		}
+	} else {
+		_, err = io.Copy(io.Discard, counter)
+	}
+
+	transfer := newTransferLog("received", counter.n, err == nil, start)
+	setSpanAttribute(r.Context(), "debug_httpd.transfer.bytes", counter.n)
+	logger.Update(logID, func(log *AccessLog) {
+		log.Transfer = transfer
+	})
+
+	result := map[string]interface{}{
+		"bytes":             transfer.Bytes,
+		"sha256":            hex.EncodeToString(counter.hash.Sum(nil)),
+		"complete":          transfer.Complete,
+		"duration":          transfer.Duration,
+		"bytes_per_second":  transfer.BytesPerSecond,
+		"throughput":        transfer.Throughput,
+		"content_length":    r.ContentLength,
+		"content_type":      r.Header.Get("Content-Type"),
+		"transfer_encoding": r.TransferEncoding,
+	}
+	if parts != nil {
+		result["parts"] = parts
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+			result["error"] = fmt.Sprintf("request body exceeds limit of %d bytes", limit)
+		} else {
+			status = http.StatusBadRequest
+			result["error"] = fmt.Sprintf("failed to read request body: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadHandler(t *testing.T) {
+	logger = NewAccessLogger(100)
+	body := strings.Repeat("upload", 1000)
+
+	req, err := http.NewRequest("POST", "/upload", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(uploadHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(body))
+	if response["sha256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected sha256: %v", response["sha256"])
+	}
+	if response["bytes"].(float64) != 6000 || response["complete"] != true {
+		t.Errorf("unexpected result: %v", response)
+	}
+
+	logs := logger.GetLogs()
+	transfer := logs[len(logs)-1].Transfer
+	if transfer == nil || transfer.Direction != "received" || transfer.Bytes != 6000 {
+		t.Errorf("unexpected transfer log: %+v", transfer)
+	}
+}
+
+func TestUploadHandler_Multipart(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("comment", "hello")
+	fw, _ := mw.CreateFormFile("file", "data.bin")
+	fw.Write(bytes.Repeat([]byte{0xff}, 4096))
+	mw.Close()
+	raw := buf.Bytes()
+
+	req, err := http.NewRequest("POST", "/upload", bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(uploadHandler)
+	handler.ServeHTTP(rr, req)
+
+	var response struct {
+		Bytes  int64  `json:"bytes"`
+		SHA256 string `json:"sha256"`
+		Parts  []struct {
+			Name     string `json:"name"`
+			Filename string `json:"filename"`
+			Bytes    int64  `json:"bytes"`
+		} `json:"parts"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	// The hash covers the raw body, not just the parts
+	sum := sha256.Sum256(raw)
+	if response.Bytes != int64(len(raw)) || response.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected body summary: %d bytes, sha256 %s", response.Bytes, response.SHA256)
+	}
+	if len(response.Parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(response.Parts))
+	}
+	if p := response.Parts[0]; p.Name != "comment" || p.Bytes != 5 {
+		t.Errorf("unexpected first part: %+v", p)
+	}
+	if p := response.Parts[1]; p.Name != "file" || p.Filename != "data.bin" || p.Bytes != 4096 {
+		t.Errorf("unexpected second part: %+v", p)
+	}
+}
+
+func TestUploadHandler_Limit(t *testing.T) {
+	req, err := http.NewRequest("PUT", "/upload?limit=1KiB", strings.NewReader(strings.Repeat("x", 2048)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(uploadHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestUploadHandler_Rate(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	// Unknown length, so the body is sent chunked
+	start := time.Now()
+	resp, err := http.Post(server.URL+"/upload?rate=50KB", "application/octet-stream",
+		io.MultiReader(strings.NewReader(strings.Repeat("x", 10000))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("body was not read slowly: %v", elapsed)
+	}
+
+	var response map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&response)
+	if response["bytes"].(float64) != 10000 {
+		t.Errorf("unexpected byte count: %v", response["bytes"])
+	}
+	if te, _ := response["transfer_encoding"].([]interface{}); len(te) != 1 || te[0] != "chunked" {
+		t.Errorf("unexpected transfer_encoding: %v", response["transfer_encoding"])
+	}
+}
+
+func TestUploadHandler_InvalidParams(t *testing.T) {
+	for _, path := range []string{"/upload?rate=abc", "/upload?limit=0", "/upload?delay=abc"} {
+		req, err := http.NewRequest("POST", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(uploadHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, status, http.StatusBadRequest)
+		}
+	}
+}
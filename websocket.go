@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket opcodes (RFC 6455)
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// WebSocket close codes used by the server
+const (
+	wsCloseNormal          = 1000
+	wsCloseProtocolError   = 1002
+	wsCloseMessageTooLarge = 1009
+)
+
+// wsGUID is the magic value used to compute Sec-WebSocket-Accept
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Limits for incoming messages and the !large command
+const (
+	maxWSMessageSize = 16 << 20
+	maxWSLargeFrame  = 64 << 20
+)
+
+// WebSocketLog records the lifetime of a WebSocket connection
+type WebSocketLog struct {
+	Status           int    `json:"status"`
+	Duration         string `json:"duration"`
+	MessagesReceived int    `json:"messages_received"`
+	MessagesSent     int    `json:"messages_sent"`
+	CloseCode        int    `json:"close_code,omitempty"`
+	ClosedBy         string `json:"closed_by"`
+}
+
+// wsConn is a server side WebSocket connection
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	mu               sync.Mutex
+	closed           bool
+	messagesReceived int
+	messagesSent     int
+	closeCode        int
+	closedBy         string
+}
+
+// wsHandler handles /ws requests: it upgrades the connection to WebSocket
+// and echoes messages, interpreting messages starting with "!" as commands
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	logID := logAccess(r)
+
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "websocket upgrade required",
+			"example": "websocat ws://localhost:9876/ws",
+		})
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Sec-WebSocket-Version must be 13 and Sec-WebSocket-Key is required",
+		})
+		return
+	}
+
+	var pingInterval time.Duration
+	if pingStr := r.URL.Query().Get("ping"); pingStr != "" {
+		var err error
+		pingInterval, err = time.ParseDuration(pingStr)
+		if err != nil || pingInterval <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "ping must be a positive duration",
+				"example": "/ws?ping=30s",
+			})
+			return
+		}
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("could not hijack connection: %v", err),
+		})
+		return
+	}
+	defer conn.Close()
+
+	// The handshake carries the headers set by the middleware, such as
+	// trace, affinity and CORS headers
+	header := w.Header().Clone()
+	header.Del("Content-Length")
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", wsAcceptKey(key))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(rw)
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	setSpanStatus(r.Context(), http.StatusSwitchingProtocols)
+	logger.Update(logID, func(log *AccessLog) {
+		log.WebSocket = &WebSocketLog{Status: http.StatusSwitchingProtocols}
+	})
+
+	ws := &wsConn{conn: conn, rw: rw}
+	startTime := time.Now()
+
+	done := make(chan struct{})
+	if pingInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(pingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if ws.write(wsOpPing, []byte(time.Now().Format(time.RFC3339Nano))) != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	ws.serve()
+	close(done)
+
+	duration := time.Since(startTime)
+	ws.mu.Lock()
+	wsLog := &WebSocketLog{
+		Status:           http.StatusSwitchingProtocols,
+		Duration:         duration.String(),
+		MessagesReceived: ws.messagesReceived,
+		MessagesSent:     ws.messagesSent,
+		CloseCode:        ws.closeCode,
+		ClosedBy:         ws.closedBy,
+	}
+	ws.mu.Unlock()
+
+	logger.Update(logID, func(log *AccessLog) {
+		log.WebSocket = wsLog
+	})
+	fmt.Printf("[%s] WebSocket %s from %s closed by %s after %s (received %d, sent %d)\n",
+		time.Now().Format(time.RFC3339Nano), r.URL.Path, r.RemoteAddr,
+		wsLog.ClosedBy, wsLog.Duration, wsLog.MessagesReceived, wsLog.MessagesSent)
+}
+
+// serve reads messages until the connection is closed
+func (ws *wsConn) serve() {
+	var message []byte
+	var messageOp byte
+
+	for {
+		fin, opcode, payload, err := readWSFrame(ws.rw.Reader, maxWSMessageSize, true)
+		if err != nil {
+			if errors.Is(err, errWSFrameTooLarge) {
+				ws.close(wsCloseMessageTooLarge, "message too large", "server")
+			} else if errors.Is(err, errWSProtocol) {
+				ws.close(wsCloseProtocolError, err.Error(), "server")
+			} else {
+				ws.markClosed(0, "client")
+			}
+			return
+		}
+
+		switch opcode {
+		case wsOpPing:
+			ws.write(wsOpPong, payload)
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code := 0
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			if len(payload) == 1 || (code != 0 && !validWSCloseCode(code)) || !utf8.Valid(payload[min(len(payload), 2):]) {
+				ws.close(wsCloseProtocolError, "invalid close frame", "server")
+				return
+			}
+			ws.markClosed(code, "client")
+			// Echo the close frame to complete the closing handshake
+			ws.rawWrite(wsOpClose, payload)
+			return
+		case wsOpText, wsOpBinary:
+			if message != nil {
+				ws.close(wsCloseProtocolError, "expected continuation frame", "server")
+				return
+			}
+			messageOp = opcode
+			message = append([]byte{}, payload...)
+		case wsOpContinuation:
+			if message == nil {
+				ws.close(wsCloseProtocolError, "unexpected continuation frame", "server")
+				return
+			}
+			message = append(message, payload...)
+		default:
+			ws.close(wsCloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode), "server")
+			return
+		}
+
+		if len(message) > maxWSMessageSize {
+			ws.close(wsCloseMessageTooLarge, "message too large", "server")
+			return
+		}
+		if !fin {
+			continue
+		}
+
+		ws.mu.Lock()
+		ws.messagesReceived++
+		ws.mu.Unlock()
+
+		if messageOp == wsOpText && strings.HasPrefix(string(message), "!") {
+			if stop := ws.command(string(message)); stop {
+				ws.awaitClose()
+				return
+			}
+		} else {
+			ws.write(messageOp, message)
+		}
+		message = nil
+	}
+}
+
+// command runs a "!" command and reports whether the connection was closed
+func (ws *wsConn) command(text string) bool {
+	fields := strings.Fields(text)
+
+	switch fields[0] {
+	case "!ping":
+		ws.write(wsOpPing, []byte(strings.Join(fields[1:], " ")))
+	case "!delay":
+		if len(fields) < 2 {
+			ws.write(wsOpText, []byte("error: usage: !delay <duration> [message]"))
+			break
+		}
+		delay, err := time.ParseDuration(fields[1])
+		if err != nil || delay < 0 || delay > time.Hour {
+			ws.write(wsOpText, []byte("error: invalid duration: "+fields[1]))
+			break
+		}
+		reply := []byte(strings.Join(fields[2:], " "))
+		time.AfterFunc(delay, func() {
+			ws.write(wsOpText, reply)
+		})
+	case "!large":
+		if len(fields) < 2 {
+			ws.write(wsOpText, []byte("error: usage: !large <bytes>"))
+			break
+		}
+		size, err := strconv.Atoi(fields[1])
+		if err != nil || size < 0 || size > maxWSLargeFrame {
+			ws.write(wsOpText, []byte(fmt.Sprintf("error: size must be between 0 and %d", maxWSLargeFrame)))
+			break
+		}
+		payload := make([]byte, size)
+		rand.Read(payload)
+		ws.write(wsOpBinary, payload)
+	case "!close":
+		code := wsCloseNormal
+		if len(fields) >= 2 {
+			var err error
+			code, err = strconv.Atoi(fields[1])
+			if err != nil || code < 1000 || code > 4999 {
+				ws.write(wsOpText, []byte("error: close code must be between 1000 and 4999"))
+				break
+			}
+		}
+		ws.close(code, strings.Join(fields[min(len(fields), 2):], " "), "server")
+		return true
+	case "!help":
+		ws.write(wsOpText, []byte(strings.Join([]string{
+			"!ping [payload]            send a ping frame",
+			"!delay <duration> [text]   reply with text after duration",
+			"!large <bytes>             send a binary frame of the given size",
+			"!close [code] [reason]     close the connection",
+		}, "\n")))
+	default:
+		ws.write(wsOpText, []byte("error: unknown command "+fields[0]+" (try !help)"))
+	}
+	return false
+}
+
+// awaitClose waits briefly for the client to acknowledge a close frame sent
+// by the server, discarding any other frames
+func (ws *wsConn) awaitClose() {
+	ws.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, opcode, _, err := readWSFrame(ws.rw.Reader, maxWSMessageSize, true)
+		if err != nil || opcode == wsOpClose {
+			return
+		}
+	}
+}
+
+// write sends a frame and counts data messages
+func (ws *wsConn) write(opcode byte, payload []byte) error {
+	err := ws.rawWrite(opcode, payload)
+	if err == nil && (opcode == wsOpText || opcode == wsOpBinary) {
+		ws.mu.Lock()
+		ws.messagesSent++
+		ws.mu.Unlock()
+	}
+	return err
+}
+
+// rawWrite sends a single frame unless the connection has been closed
+func (ws *wsConn) rawWrite(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.closed && opcode != wsOpClose {
+		return net.ErrClosed
+	}
+	if err := writeWSFrame(ws.rw.Writer, opcode, payload, false); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
+
+// close sends a close frame with code and reason
+func (ws *wsConn) close(code int, reason, closedBy string) {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload = append(payload, reason...)
+
+	ws.markClosed(code, closedBy)
+	ws.rawWrite(wsOpClose, payload)
+}
+
+// validWSCloseCode reports whether code may be sent in a close frame. 1005,
+// 1006 and 1015 are reserved for reporting locally (RFC 6455 section 7.4.1).
+func validWSCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != 1005 && code != 1006
+}
+
+// markClosed records who closed the connection, keeping the first record
+func (ws *wsConn) markClosed(code int, closedBy string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if !ws.closed {
+		ws.closed = true
+		ws.closeCode = code
+		ws.closedBy = closedBy
+	}
+}
+
+var (
+	errWSProtocol      = errors.New("websocket protocol error")
+	errWSFrameTooLarge = errors.New("websocket frame too large")
+)
+
+// readWSFrame reads a single frame, unmasking the payload if needed. mask
+// tells whether the frame must be masked, as frames from clients must be.
+func readWSFrame(r io.Reader, maxSize int, mask bool) (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", errWSProtocol)
+	}
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	if masked != mask {
+		return false, 0, nil, fmt.Errorf("%w: masked bit is %t", errWSProtocol, masked)
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if opcode >= wsOpClose && (length > 125 || !fin) {
+		return false, 0, nil, fmt.Errorf("%w: invalid control frame", errWSProtocol)
+	}
+	if length > uint64(maxSize) {
+		return false, 0, nil, errWSFrameTooLarge
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeWSFrame writes a single final frame. Clients must mask their frames.
+func writeWSFrame(w io.Writer, opcode byte, payload []byte, mask bool) error {
+	header := []byte{0x80 | opcode, 0}
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if mask {
+		header[1] |= 0x80
+		var key [4]byte
+		rand.Read(key[:])
+		header = append(header, key[:]...)
+		masked := make([]byte, length)
+		for i := range payload {
+			masked[i] = payload[i] ^ key[i%4]
+		}
+		payload = masked
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// wsAcceptKey computes the Sec-WebSocket-Accept value for key
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContainsToken reports whether the comma separated header name
+// contains token, ignoring case
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialWebSocket performs the opening handshake against the server at
+// baseURL and returns the connection and a reader for its frames
+func dialWebSocket(t *testing.T, baseURL, path string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(baseURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req, _ := http.NewRequest("GET", baseURL+path, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	// Example from RFC 6455 section 1.3
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected Sec-WebSocket-Accept: %v", accept)
+	}
+	return conn, br
+}
+
+func readTestFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	fin, opcode, payload, err := readWSFrame(br, maxWSLargeFrame, false)
+	if err != nil {
+		t.Fatalf("could not read frame: %v", err)
+	}
+	if !fin {
+		t.Fatal("server sent a fragmented frame")
+	}
+	return opcode, payload
+}
+
+func TestWSHandler(t *testing.T) {
+	logger = NewAccessLogger(100)
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	conn, br := dialWebSocket(t, server.URL, "/ws")
+
+	// Echo
+	writeWSFrame(conn, wsOpText, []byte("hello"), true)
+	if opcode, payload := readTestFrame(t, br); opcode != wsOpText || string(payload) != "hello" {
+		t.Errorf("unexpected echo: opcode %d payload %q", opcode, payload)
+	}
+
+	// Fragmented messages are reassembled before echoing
+	conn.Write([]byte{0x01, 0x83, 0, 0, 0, 0, 'f', 'o', 'o'})
+	writeWSFrame(conn, wsOpContinuation, []byte("bar"), true)
+	if opcode, payload := readTestFrame(t, br); opcode != wsOpText || string(payload) != "foobar" {
+		t.Errorf("unexpected fragmented echo: opcode %d payload %q", opcode, payload)
+	}
+
+	// Client ping gets a pong
+	writeWSFrame(conn, wsOpPing, []byte("p"), true)
+	if opcode, payload := readTestFrame(t, br); opcode != wsOpPong || string(payload) != "p" {
+		t.Errorf("unexpected pong: opcode %d payload %q", opcode, payload)
+	}
+
+	// Server initiated ping
+	writeWSFrame(conn, wsOpText, []byte("!ping abc"), true)
+	if opcode, payload := readTestFrame(t, br); opcode != wsOpPing || string(payload) != "abc" {
+		t.Errorf("unexpected ping: opcode %d payload %q", opcode, payload)
+	}
+
+	// Delayed reply
+	start := time.Now()
+	writeWSFrame(conn, wsOpText, []byte("!delay 100ms later"), true)
+	if opcode, payload := readTestFrame(t, br); opcode != wsOpText || string(payload) != "later" {
+		t.Errorf("unexpected delayed reply: opcode %d payload %q", opcode, payload)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("delayed reply arrived too early: %v", elapsed)
+	}
+
+	// Large frame
+	writeWSFrame(conn, wsOpText, []byte("!large 100000"), true)
+	if opcode, payload := readTestFrame(t, br); opcode != wsOpBinary || len(payload) != 100000 {
+		t.Errorf("unexpected large frame: opcode %d length %d", opcode, len(payload))
+	}
+
+	// Close with a chosen code
+	writeWSFrame(conn, wsOpText, []byte("!close 4000 bye now"), true)
+	opcode, payload := readTestFrame(t, br)
+	if opcode != wsOpClose || len(payload) < 2 {
+		t.Fatalf("expected close frame, got opcode %d payload %q", opcode, payload)
+	}
+	if code := binary.BigEndian.Uint16(payload); code != 4000 || string(payload[2:]) != "bye now" {
+		t.Errorf("unexpected close frame: code %d reason %q", code, payload[2:])
+	}
+	writeWSFrame(conn, wsOpClose, payload[:2], true)
+
+	// The access log entry is updated once the connection is done
+	var wsLog *WebSocketLog
+	for i := 0; i < 50 && (wsLog == nil || wsLog.ClosedBy == ""); i++ {
+		time.Sleep(20 * time.Millisecond)
+		for _, log := range logger.GetLogs() {
+			if log.Path == "/ws" {
+				wsLog = log.WebSocket
+			}
+		}
+	}
+	if wsLog == nil {
+		t.Fatal("access log missing websocket details")
+	}
+	if wsLog.Status != http.StatusSwitchingProtocols || wsLog.MessagesReceived != 6 || wsLog.MessagesSent != 4 || wsLog.CloseCode != 4000 || wsLog.ClosedBy != "server" {
+		t.Errorf("unexpected websocket log: %+v", wsLog)
+	}
+}
+
+func TestWSHandler_HandshakeHeaders(t *testing.T) {
+	withAffinityCookie(t, "app-1")
+	collector, collectorServer := startFakeCollector(t)
+	withExporter(t, NewOTLPExporter(collectorServer.URL, "test-service", ""))
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	req, _ := http.NewRequest("GET", server.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Write(conn)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Headers set by the middleware are part of the handshake
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "websocket" {
+		t.Fatalf("unexpected handshake: %v %v", resp.Status, resp.Header)
+	}
+	for _, name := range []string{"Traceparent", "X-Request-Id", "X-Affinity", "Set-Cookie"} {
+		if resp.Header.Get(name) == "" {
+			t.Errorf("handshake missing %s: %v", name, resp.Header)
+		}
+	}
+
+	writeWSFrame(conn, wsOpClose, []byte{0x03, 0xe8}, true)
+	readTestFrame(t, br)
+	conn.Close()
+
+	// The span records 101 once the handler returns
+	for i := 0; i < 50; i++ {
+		exporter.Flush()
+		collector.mu.Lock()
+		payloads := collector.payloads["/v1/traces"]
+		collector.mu.Unlock()
+		if len(payloads) > 0 {
+			span := first(first(payloads[0], "resourceSpans"), "scopeSpans")["spans"].([]interface{})[0]
+			if status := attributeMap(span.(map[string]interface{})["attributes"])["http.response.status_code"]; status != "101" {
+				t.Errorf("span status got %v want 101", status)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("no span exported")
+}
+
+func TestWSHandler_PingInterval(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	conn, br := dialWebSocket(t, server.URL, "/ws?ping=50ms")
+
+	for i := 0; i < 2; i++ {
+		if opcode, _ := readTestFrame(t, br); opcode != wsOpPing {
+			t.Errorf("expected ping frame, got opcode %d", opcode)
+		}
+	}
+
+	writeWSFrame(conn, wsOpClose, []byte{0x03, 0xe8}, true)
+	for {
+		opcode, payload := readTestFrame(t, br)
+		if opcode == wsOpClose {
+			if code := binary.BigEndian.Uint16(payload); code != wsCloseNormal {
+				t.Errorf("unexpected close code: %d", code)
+			}
+			break
+		}
+	}
+}
+
+func TestWSHandler_ProtocolErrors(t *testing.T) {
+	server := httptest.NewServer(newHandler())
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		opcode  byte
+		payload []byte
+		mask    bool
+	}{
+		{"unmasked frame", wsOpText, []byte("hello"), false},
+		{"close code 999", wsOpClose, []byte{0x03, 0xe7}, true},
+		{"close code 1005", wsOpClose, []byte{0x03, 0xed}, true},
+		{"close code 1006", wsOpClose, []byte{0x03, 0xee}, true},
+		{"close code 1015", wsOpClose, []byte{0x03, 0xf7}, true},
+		{"close code 2000", wsOpClose, []byte{0x07, 0xd0}, true},
+		{"one byte close payload", wsOpClose, []byte{0x03}, true},
+		{"invalid close reason", wsOpClose, []byte{0x03, 0xe8, 0xff}, true},
+	}
+
+	for _, tt := range tests {
+		conn, br := dialWebSocket(t, server.URL, "/ws")
+		writeWSFrame(conn, tt.opcode, tt.payload, tt.mask)
+		opcode, payload := readTestFrame(t, br)
+		if opcode != wsOpClose || len(payload) < 2 {
+			t.Errorf("%s: expected close frame, got opcode %d payload %q", tt.name, opcode, payload)
+			continue
+		}
+		if code := binary.BigEndian.Uint16(payload); code != wsCloseProtocolError {
+			t.Errorf("%s: close code got %d want %d", tt.name, code, wsCloseProtocolError)
+		}
+	}
+}
+
+func TestValidWSCloseCode(t *testing.T) {
+	for code, want := range map[int]bool{
+		999: false, 1000: true, 1003: true, 1004: false, 1005: false, 1006: false,
+		1007: true, 1011: true, 1014: true, 1015: false, 2999: false, 3000: true, 4999: true, 5000: false,
+	} {
+		if got := validWSCloseCode(code); got != want {
+			t.Errorf("validWSCloseCode(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestWSHandler_NotUpgrade(t *testing.T) {
+	req, err := http.NewRequest("GET", "/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(wsHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUpgradeRequired {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUpgradeRequired)
+	}
+}
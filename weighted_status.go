@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxSeededStatusStreams bounds the number of seeded random streams kept
+// for weighted /status requests
+const maxSeededStatusStreams = 1000
+
+// maxStatusWeight bounds each weight of a weighted /status distribution,
+// and maxStatusWeightTotal their sum
+const (
+	maxStatusWeight      = 1000000
+	maxStatusWeightTotal = 1000000000
+)
+
+// weightedStatus is a status code and its relative weight
+type weightedStatus struct {
+	code   int
+	weight int
+}
+
+// seededStatusStreams holds a random stream per seed and distribution, so
+// the sequence of codes for a seed is the same after every restart
+var seededStatusStreams = struct {
+	sync.Mutex
+	streams map[string]*rand.Rand
+}{streams: map[string]*rand.Rand{}}
+
+// parseWeightedStatuses parses a distribution such as "200:90,500:8,503:2".
+// A code without a weight has weight 1.
+func parseWeightedStatuses(spec string) ([]weightedStatus, int, error) {
+	var statuses []weightedStatus
+	total := 0
+	for _, entry := range strings.Split(spec, ",") {
+		codeStr, weightStr, hasWeight := strings.Cut(strings.TrimSpace(entry), ":")
+		code, err := strconv.Atoi(codeStr)
+		if err != nil || code < 100 || code > 599 {
+			return nil, 0, fmt.Errorf("invalid status code %q (must be between 100 and 599)", codeStr)
+		}
+		weight := 1
+		if hasWeight {
+			weight, err = strconv.Atoi(weightStr)
+			if err != nil || weight < 0 || weight > maxStatusWeight {
+				return nil, 0, fmt.Errorf("invalid weight %q for status %d (must be between 0 and %d)", weightStr, code, maxStatusWeight)
+			}
+		}
+		statuses = append(statuses, weightedStatus{code: code, weight: weight})
+		total += weight
+		if total > maxStatusWeightTotal {
+			return nil, 0, fmt.Errorf("weights must not add up to more than %d", maxStatusWeightTotal)
+		}
+	}
+	if total == 0 {
+		return nil, 0, fmt.Errorf("weights must not all be zero")
+	}
+	return statuses, total, nil
+}
+
+// pickWeightedStatus chooses a status code from spec for r. With by=client
+// the choice is fixed for each client (the X-Client-Id header, or else the
+// client IP address); with a seed the sequence of choices is reproducible.
+func pickWeightedStatus(r *http.Request, spec string) (int, error) {
+	statuses, total, err := parseWeightedStatuses(spec)
+	if err != nil {
+		return 0, err
+	}
+
+	query := r.URL.Query()
+	var seed uint64
+	seeded := query.Get("seed") != ""
+	if seeded {
+		seed, err = strconv.ParseUint(query.Get("seed"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("seed must be an unsigned integer")
+		}
+	}
+
+	var n int
+	switch query.Get("by") {
+	case "client":
+		client := r.Header.Get("X-Client-Id")
+		if client == "" {
+			client, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d/%s", seed, client)
+		n = int(h.Sum64() % uint64(total))
+	case "", "request":
+		if seeded {
+			n = seededStatusIntn(fmt.Sprintf("%d/%s", seed, spec), int64(seed), total)
+		} else {
+			n = rand.Intn(total)
+		}
+	default:
+		return 0, fmt.Errorf("by must be request or client")
+	}
+
+	for _, status := range statuses {
+		if n < status.weight {
+			return status.code, nil
+		}
+		n -= status.weight
+	}
+	return statuses[len(statuses)-1].code, nil
+}
+
+// seededStatusIntn returns the next number in [0, n) from the stream for key
+func seededStatusIntn(key string, seed int64, n int) int {
+	seededStatusStreams.Lock()
+	defer seededStatusStreams.Unlock()
+
+	stream, ok := seededStatusStreams.streams[key]
+	if !ok {
+		if len(seededStatusStreams.streams) >= maxSeededStatusStreams {
+			seededStatusStreams.streams = map[string]*rand.Rand{}
+		}
+		stream = rand.New(rand.NewSource(seed))
+		seededStatusStreams.streams[key] = stream
+	}
+	return stream.Intn(n)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// requestStatus runs statusHandler for path and returns the status code
+func requestStatus(t *testing.T, path string, clientID string) int {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientID != "" {
+		req.Header.Set("X-Client-Id", clientID)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(statusHandler)
+	handler.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestStatusHandler_Weighted(t *testing.T) {
+	counts := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		counts[requestStatus(t, "/status/200:90,500:10", "")]++
+	}
+
+	if len(counts) != 2 {
+		t.Fatalf("unexpected status codes: %v", counts)
+	}
+	if counts[500] < 50 || counts[500] > 150 {
+		t.Errorf("500 chosen %d times out of 1000, expected about 100", counts[500])
+	}
+
+	// Zero weights are never chosen, query form works too
+	for i := 0; i < 100; i++ {
+		if code := requestStatus(t, "/status/?codes=200:0,503:1", ""); code != 503 {
+			t.Fatalf("unexpected status code: %v", code)
+		}
+	}
+}
+
+func TestStatusHandler_WeightedSeed(t *testing.T) {
+	sequence := func() []int {
+		seededStatusStreams.streams = map[string]*rand.Rand{}
+		var codes []int
+		for i := 0; i < 20; i++ {
+			codes = append(codes, requestStatus(t, "/status/200,500,503?seed=42", ""))
+		}
+		return codes
+	}
+
+	first, second := sequence(), sequence()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("seeded sequences differ: %v vs %v", first, second)
+		}
+	}
+}
+
+func TestStatusHandler_WeightedByClient(t *testing.T) {
+	seen := map[int]bool{}
+	for _, client := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		code := requestStatus(t, "/status/200,500?by=client", client)
+		seen[code] = true
+		for i := 0; i < 10; i++ {
+			if again := requestStatus(t, "/status/200,500?by=client", client); again != code {
+				t.Fatalf("client %s got %d then %d", client, code, again)
+			}
+		}
+	}
+	if len(seen) != 2 {
+		t.Errorf("expected clients to be spread over both codes, got %v", seen)
+	}
+}
+
+func TestStatusHandler_WeightedLogsChosenStatus(t *testing.T) {
+	logger = NewAccessLogger(100)
+	code := requestStatus(t, "/status/418:1,200:0", "")
+
+	logs := logger.GetLogs()
+	if code != 418 || logs[len(logs)-1].ChosenStatus != 418 {
+		t.Errorf("unexpected chosen status: response %d, log %d", code, logs[len(logs)-1].ChosenStatus)
+	}
+}
+
+func TestStatusHandler_WeightedInvalid(t *testing.T) {
+	tests := []string{
+		"/status/200:90,abc:10",
+		"/status/200:-1,500:1",
+		"/status/200:0,500:0",
+		"/status/200:1,600:1",
+		"/status/200,500?seed=abc",
+		"/status/200,500?by=host",
+		"/status/200:9223372036854775807,500:1",
+		"/status/200:1000001",
+		"/status/" + strings.Repeat("200:1000000,", 1000) + "500:1",
+	}
+
+	for _, path := range tests {
+		if code := requestStatus(t, path, ""); code != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, code, http.StatusBadRequest)
+		}
+	}
+}